@@ -2,13 +2,19 @@ package main
 
 import (
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"rvadim/loggo/pkg/transport/firehose"
 	"syscall"
+	"time"
 
 	"rvadim/loggo/pkg/config"
+	"rvadim/loggo/pkg/delivery"
 	"rvadim/loggo/pkg/docker"
+	"rvadim/loggo/pkg/health"
+	"rvadim/loggo/pkg/k8s"
+	"rvadim/loggo/pkg/queue"
 	"rvadim/loggo/pkg/service"
 	"rvadim/loggo/pkg/storage"
 	"rvadim/loggo/pkg/transport"
@@ -41,19 +47,64 @@ func main() {
 		}
 	}
 
+	backoff := delivery.NewBackoffFromConfig(c)
+	if c.StartupHealthTries > 0 {
+		// Agent starts anyway, records wait in queue and breaker, readiness endpoint reports transport health
+		if err := health.WaitHealthy(broker, c.StartupHealthTries, backoff); err != nil {
+			log.Printf("Start with unhealthy transport, %s", err)
+		}
+	}
+	stop := make(chan bool)
+	checker := health.NewChecker(broker, time.Duration(c.HealthCheckIntervalSec)*time.Second)
+	http.Handle("/ready", checker)
+	go checker.Run(stop)
+
+	if c.BreakerThreshold > 0 {
+		broker = delivery.NewBreaker(c.Transport, broker, c.BreakerThreshold, backoff)
+	}
+
+	if c.QueuePath != "" {
+		broker, err = queue.New(c.QueuePath, broker, int64(c.QueueMaxSizeMB)*1024*1024, c.QueueMaxBatches, backoff)
+		if err != nil {
+			log.Fatalf("Unable to init delivery queue. %s", err)
+		}
+	}
+
 	registry, err := storage.NewRegistryFile(c.PositionFilePath, 1)
 	if err != nil {
 		log.Fatalln(err)
 	}
 	defer registry.Close()
 
-	finder, err := docker.NewFinder(c.LogsPath)
-	if err != nil {
-		log.Fatalln(err)
+	var finder service.LogsFinder
+	if c.Finder == "docker-api" {
+		apiFinder := docker.NewAPIFinder(c.DockerSocket)
+		go apiFinder.Run(stop)
+		finder = apiFinder
+	} else {
+		filesFinder, err := docker.NewFinder(c.LogsPath)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		filesFinder.SetCRIOStoragePath(c.CRIOStoragePath)
+		finder = filesFinder
 	}
 
 	s := service.NewService(c, registry, broker, finder)
 
+	if c.K8sMetadata {
+		pods, err := k8s.NewInClusterPodsProvider(c.K8sAPIURL, c.K8sNodeName)
+		if err != nil {
+			log.Fatalf("Unable to init Kubernetes pods provider. %s", err)
+		}
+		// Listing is limited by timeout, pods listed later are added to records of running readers
+		if err := pods.Sync(); err != nil {
+			log.Printf("Unable to list pods of node %s, %s", c.K8sNodeName, err)
+		}
+		go pods.Run(stop)
+		s.SetPods(pods)
+	}
+
 	go s.Start()
 
 	// Handle SIGINT and SIGTERM.
@@ -62,6 +113,7 @@ func main() {
 	log.Printf("Catched signal '%s'", <-ch)
 
 	// Stop the service gracefully.
+	close(stop)
 	s.Stop()
 	log.Println("Loggo successfully stopped now.")
 }
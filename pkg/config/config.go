@@ -5,10 +5,15 @@ import (
 	"reflect"
 
 	"regexp"
+	"strings"
 
 	"log"
 
 	"gopkg.in/alecthomas/kingpin.v2"
+
+	"rvadim/loggo/pkg/multiline"
+	"rvadim/loggo/pkg/parser"
+	"rvadim/loggo/pkg/ratelimit"
 )
 
 // Config store all configuration options
@@ -17,6 +22,9 @@ type Config struct {
 	PositionFilePath       string
 	DirRereadIntervalSec   int
 	ReaderMaxChunk         int
+	ReaderMaxLineBytes     int
+	OutputSchema           string
+	ReaderOversizedAction  string
 	ReaderTimeoutSec       int
 	AMQPURL                string
 	AMQPExchange           string
@@ -35,6 +43,55 @@ type Config struct {
 	excludeRegex           string
 	includeRegex           string
 	FireHoseDeliveryStream string
+	QueuePath              string
+	QueueMaxSizeMB         int
+	QueueMaxBatches        int
+	BackoffInitialMs       int
+	BackoffMaxSec          int
+	BackoffMultiplier      float64
+	BackoffJitter          float64
+	BreakerThreshold       int
+	StartupHealthTries     int
+	HealthCheckIntervalSec int
+	MultilineRules         []*multiline.Rule
+	MultilineMaxLines      int
+	MultilineMaxBytes      int
+	MultilineTimeoutSec    int
+	multilineRules         []string
+	PartialMaxBytes        int
+	PartialTimeoutSec      int
+	CRIOStoragePath        string
+	Finder                 string
+	DockerSocket           string
+	K8sMetadata            bool
+	K8sAPIURL              string
+	K8sNodeName            string
+	K8sLabels              []string
+	K8sAnnotations         []string
+	ParserOptions          parser.Options
+	BodyFormatRules        []*parser.FormatRule
+	bodyFormatRules        []string
+	GrokRules              []*parser.GrokRule
+	FilterRules            []*parser.FilterRule
+	RateLimitRules         []*ratelimit.Rule
+	DedupRules             []*parser.DedupRule
+	DedupKey               string
+	DedupWindowSec         int
+	DedupMaxBytes          int
+	dedupRules             []string
+	SampleRules            []*ratelimit.SampleRule
+	rateLimitRules         []string
+	sampleRules            []string
+	filterRules            []string
+	GrokLibrary            map[string]string
+	grokRules              []string
+	grokPatterns           []string
+	processors             string
+	renameFields           []string
+	addFields              []string
+	coerceRules            []string
+	coerceDefault          bool
+	redactPatterns         []string
 }
 
 // GetConfig generate Config from options and env vars
@@ -72,10 +129,64 @@ func GetConfig() *Config {
 		Default("my-delivery").
 		Envar("FIREHOSE_DELIVERY_STREAM").
 		StringVar(&c.FireHoseDeliveryStream)
+	kingpin.Flag("queue-path", "Path to disk queue file between readers and transport, empty disables queue").
+		Default("").
+		Envar("QUEUE_PATH").
+		StringVar(&c.QueuePath)
+	kingpin.Flag("queue-max-size-mb", "Maximum size of disk queue in megabytes, 0 means unlimited").
+		Default("512").
+		Envar("QUEUE_MAX_SIZE_MB").
+		IntVar(&c.QueueMaxSizeMB)
+	kingpin.Flag("queue-max-batches", "Maximum number of batches in disk queue, 0 means unlimited").
+		Default("100000").
+		Envar("QUEUE_MAX_BATCHES").
+		IntVar(&c.QueueMaxBatches)
+	kingpin.Flag("backoff-initial-ms", "Delay before first retry of failed delivery in milliseconds").
+		Default("500").
+		Envar("BACKOFF_INITIAL_MS").
+		IntVar(&c.BackoffInitialMs)
+	kingpin.Flag("backoff-max-sec", "Maximum delay between retries of failed delivery in seconds").
+		Default("60").
+		Envar("BACKOFF_MAX_SEC").
+		IntVar(&c.BackoffMaxSec)
+	kingpin.Flag("backoff-multiplier", "How many times delay grows after each failed delivery").
+		Default("2").
+		Envar("BACKOFF_MULTIPLIER").
+		Float64Var(&c.BackoffMultiplier)
+	kingpin.Flag("backoff-jitter", "Fraction of delay randomly subtracted from it, from 0 to 1").
+		Default("0.2").
+		Envar("BACKOFF_JITTER").
+		Float64Var(&c.BackoffJitter)
+	kingpin.Flag("breaker-threshold", "Consecutive delivery failures which open circuit breaker, 0 disables breaker").
+		Default("5").
+		Envar("BREAKER_THRESHOLD").
+		IntVar(&c.BreakerThreshold)
+	kingpin.Flag("startup-health-tries", "How many times check transport health at startup before start reading logs, "+
+		"agent starts anyway if transport is not healthy, 0 disables check").
+		Default("0").
+		Envar("STARTUP_HEALTH_TRIES").
+		IntVar(&c.StartupHealthTries)
+	kingpin.Flag("health-check-interval-sec", "How often check transport health for readiness endpoint").
+		Default("10").
+		Envar("HEALTH_CHECK_INTERVAL_SEC").
+		IntVar(&c.HealthCheckIntervalSec)
 	kingpin.Flag("logs-path", "Path where loggo will watch for log files").
 		Default("/var/log/pods/").
 		Envar("LOGS_PATH").
 		StringVar(&c.LogsPath)
+	kingpin.Flag("crio-storage-path", "Path where CRI-O stores containers configuration, used to detect CRI-O log files").
+		Default("/var/lib/containers/storage/overlay-containers").
+		Envar("CRIO_STORAGE_PATH").
+		StringVar(&c.CRIOStoragePath)
+	kingpin.Flag("finder", "How to find containers: by log files in logs-path or by Docker Engine API, "+
+		"log paths reported by API must be mounted at the same paths [files | docker-api]").
+		Default("files").
+		Envar("FINDER").
+		StringVar(&c.Finder)
+	kingpin.Flag("docker-socket", "Unix socket of Docker Engine API, only with finder == 'docker-api'").
+		Default("/var/run/docker.sock").
+		Envar("DOCKER_SOCKET").
+		StringVar(&c.DockerSocket)
 	kingpin.Flag("position-file-path", "Path to file where loggo store read position").
 		Default("/var/log/loggo-logs.pos").
 		Envar("POSITION_FILE_PATH").
@@ -88,6 +199,19 @@ func GetConfig() *Config {
 		Default("1000").
 		Envar("READER_MAX_CHUNK").
 		IntVar(&c.ReaderMaxChunk)
+	kingpin.Flag("reader-max-line-bytes", "Maximum size of log line, longer lines are truncated or dropped "+
+		"without reading them into memory, lines reassembled from partial entries are limited too, 0 means unlimited").
+		Default("4194304").
+		Envar("READER_MAX_LINE_BYTES").
+		IntVar(&c.ReaderMaxLineBytes)
+	kingpin.Flag("reader-oversized-action", "What to do with lines longer than reader-max-line-bytes or partial-max-bytes [truncate | drop]").
+		Default("truncate").
+		Envar("READER_OVERSIZED_ACTION").
+		StringVar(&c.ReaderOversizedAction)
+	kingpin.Flag("output-schema", "Layout of records sent to transport [logstash | ecs]").
+		Default("logstash").
+		Envar("OUTPUT_SCHEMA").
+		StringVar(&c.OutputSchema)
 	kingpin.Flag("reader-timeout-sec", "How long to wait, before start read log file which not add logs last time").
 		Default("5").
 		Envar("READER_TIMEOUT_SEC").
@@ -121,11 +245,190 @@ func GetConfig() *Config {
 		Envar("INCLUDE_REGEX").
 		StringVar(&c.includeRegex)
 
+	kingpin.Flag("k8s-metadata", "Watch pods of node through Kubernetes API, add their metadata to each log message "+
+		"and apply loggo.io/* pod annotations").
+		Envar("K8S_METADATA").
+		BoolVar(&c.K8sMetadata)
+	kingpin.Flag("k8s-api-url", "Kubernetes API address, detected from service account environment if empty").
+		Default("").
+		Envar("K8S_API_URL").
+		StringVar(&c.K8sAPIURL)
+	kingpin.Flag("k8s-node-name", "Name of node to watch pods of, node-hostname is used if empty").
+		Default("").
+		Envar("K8S_NODE_NAME").
+		StringVar(&c.K8sNodeName)
+	kingpin.Flag("k8s-label", "Pod label added to log messages, prefix ending with '*' selects several labels, can be repeated").
+		Default("*").
+		Envar("K8S_LABEL").
+		StringsVar(&c.K8sLabels)
+	kingpin.Flag("k8s-annotation", "Pod annotation added to log messages, prefix ending with '*' selects several annotations, can be repeated").
+		Envar("K8S_ANNOTATION").
+		StringsVar(&c.K8sAnnotations)
+
+	kingpin.Flag("multiline", "Multiline rule '<container-regex>:<start|continue>:<pattern>', "+
+		"the first rule matched by container name is used, can be repeated").
+		Envar("MULTILINE").
+		StringsVar(&c.multilineRules)
+	kingpin.Flag("multiline-max-lines", "Maximum lines in one multiline event, 0 means unlimited").
+		Default("500").
+		Envar("MULTILINE_MAX_LINES").
+		IntVar(&c.MultilineMaxLines)
+	kingpin.Flag("multiline-max-bytes", "Maximum size of one multiline event in bytes, 0 means unlimited").
+		Default("1048576").
+		Envar("MULTILINE_MAX_BYTES").
+		IntVar(&c.MultilineMaxBytes)
+	kingpin.Flag("multiline-timeout-sec", "How long to wait for next line of multiline event before send it").
+		Default("3").
+		Envar("MULTILINE_TIMEOUT_SEC").
+		IntVar(&c.MultilineTimeoutSec)
+	kingpin.Flag("partial-max-bytes", "Maximum size of log line reassembled from partial entries, longer lines are truncated or dropped, 0 disables reassembling").
+		Default("1048576").
+		Envar("PARTIAL_MAX_BYTES").
+		IntVar(&c.PartialMaxBytes)
+	kingpin.Flag("partial-timeout-sec", "How long to wait for the last entry of partial line (docker or CRI) before send it").
+		Default("5").
+		Envar("PARTIAL_TIMEOUT_SEC").
+		IntVar(&c.PartialTimeoutSec)
+	kingpin.Flag("processors", "Comma separated ordered list of processors applied to each log record "+
+		"[body | json | logfmt | flatten | coerce | timestamp | level | redact | rename | drop | add-fields | move]").
+		Default("body,flatten,coerce").
+		Envar("PROCESSORS").
+		StringVar(&c.processors)
+	kingpin.Flag("body-format", "Format of log body parsed by body processor [json | logfmt | auto | nginx | apache | klog]").
+		Default("json").
+		Envar("BODY_FORMAT").
+		StringVar(&c.ParserOptions.BodyFormat)
+	kingpin.Flag("body-format-rule", "Body format for containers '<container-regex>:<format>', "+
+		"the first rule matched by container name is used, can be repeated").
+		Envar("BODY_FORMAT_RULE").
+		StringsVar(&c.bodyFormatRules)
+	kingpin.Flag("grok", "Grok rule '<namespace-regex>:<container-regex>:<pattern>' applied to log of matched containers, "+
+		"pattern is a regex with named captures and %{NAME:field[:int|float]} references, can be repeated").
+		Envar("GROK").
+		StringsVar(&c.grokRules)
+	kingpin.Flag("filter", "Filter rule '<namespace-regex>:<container-regex>:<drop|keep>:[<field>]=<pattern>', "+
+		"empty field matches raw log message before parsing, rules on raw message and on parsed fields "+
+		"are applied separately, can be repeated").
+		Envar("FILTER").
+		StringsVar(&c.filterRules)
+	kingpin.Flag("rate-limit", "Rate limit rule '<namespace-regex>:<container-regex>:<rate>:<lines|bytes>:<drop|delay|sample/N>', "+
+		"rate per second applied to each matched container, the first matched rule is used, can be repeated").
+		Envar("RATE_LIMIT").
+		StringsVar(&c.rateLimitRules)
+	kingpin.Flag("sample", "Sample rule '<namespace-regex>:<container-regex>:<rate>[:<field>]', rate is part of kept records, "+
+		"records with the same field value are kept or dropped together, the first matched rule is used, can be repeated").
+		Envar("SAMPLE").
+		StringsVar(&c.sampleRules)
+	kingpin.Flag("dedup", "Dedup rule '<namespace-regex>:<container-regex>', identical consecutive records "+
+		"of matched containers are collapsed into one record with repeat_count, can be repeated").
+		Envar("DEDUP").
+		StringsVar(&c.dedupRules)
+	kingpin.Flag("dedup-key", "What makes records identical for dedup [message | message+level]").
+		Default("message").
+		Envar("DEDUP_KEY").
+		StringVar(&c.DedupKey)
+	kingpin.Flag("dedup-window-sec", "How long identical records are collapsed into one").
+		Default("10").
+		Envar("DEDUP_WINDOW_SEC").
+		IntVar(&c.DedupWindowSec)
+	kingpin.Flag("dedup-max-bytes", "Records with longer message are not collapsed, 0 means unlimited").
+		Default("65536").
+		Envar("DEDUP_MAX_BYTES").
+		IntVar(&c.DedupMaxBytes)
+	kingpin.Flag("grok-pattern", "Custom grok pattern 'NAME=regex' available to grok rules, can be repeated").
+		Envar("GROK_PATTERN").
+		StringsVar(&c.grokPatterns)
+	kingpin.Flag("coerce", "Type coercion rule '<field-glob>=<int|float|bool|duration|last>[:<suffix>]' for coerce processor, "+
+		"converted value written to field with suffix or replaces value if suffix is empty, can be repeated").
+		Envar("COERCE").
+		StringsVar(&c.coerceRules)
+	kingpin.Flag("coerce-default", "Apply default coerce rule '"+parser.DefaultCoerceRule+"' before coerce rules, "+
+		"use --no-coerce-default to disable it").
+		Default("true").
+		Envar("COERCE_DEFAULT").
+		BoolVar(&c.coerceDefault)
+	kingpin.Flag("timestamp-field", "App field with time used by timestamp processor, the first present one is used, can be repeated").
+		Default("ts", "timestamp").
+		Envar("TIMESTAMP_FIELD").
+		StringsVar(&c.ParserOptions.Timestamp.Fields)
+	kingpin.Flag("timestamp-layout", "Additional time layout in Go format used by timestamp processor, can be repeated").
+		Envar("TIMESTAMP_LAYOUT").
+		StringsVar(&c.ParserOptions.Timestamp.Layouts)
+	kingpin.Flag("timestamp-prefer-app", "Use app time instead of runtime time for @timestamp when both are present").
+		Envar("TIMESTAMP_PREFER_APP").
+		BoolVar(&c.ParserOptions.Timestamp.PreferApp)
+	kingpin.Flag("level-field", "Field with level checked by level processor in order, can be repeated").
+		Default("level", "lvl", "severity", "L", "loglevel").
+		Envar("LEVEL_FIELD").
+		StringsVar(&c.ParserOptions.LevelFields)
+	kingpin.Flag("redact-detector", "Built-in detector of sensitive values used by redact processor "+
+		"[token | aws | email | card | ip], can be repeated").
+		Default(parser.DefaultRedactDetectors...).
+		Envar("REDACT_DETECTOR").
+		StringsVar(&c.ParserOptions.Redact.Detectors)
+	kingpin.Flag("redact-pattern", "Custom detector 'name=regex' used by redact processor, can be repeated").
+		Envar("REDACT_PATTERN").
+		StringsVar(&c.redactPatterns)
+	kingpin.Flag("redact-action", "What redact processor does with sensitive values [mask | hash | drop]").
+		Default("mask").
+		Envar("REDACT_ACTION").
+		StringVar(&c.ParserOptions.Redact.Action)
+	kingpin.Flag("redact-key", "HMAC key used by hash action of redact processor").
+		Envar("REDACT_KEY").
+		StringVar(&c.ParserOptions.Redact.Key)
+	kingpin.Flag("redact-field", "Field name or glob redacted by redact processor, all fields are redacted if not set, can be repeated").
+		Envar("REDACT_FIELD").
+		StringsVar(&c.ParserOptions.Redact.Fields)
+	kingpin.Flag("rename", "Field rename 'old=new' for rename processor, can be repeated").
+		Envar("RENAME").
+		StringsVar(&c.renameFields)
+	kingpin.Flag("drop-field", "Field name or glob for drop processor, can be repeated").
+		Envar("DROP_FIELD").
+		StringsVar(&c.ParserOptions.Drop)
+	kingpin.Flag("add-field", "Field 'key=value' for add-fields processor, value may refer to record fields and properties as ${field}, can be repeated").
+		Envar("ADD_FIELD").
+		StringsVar(&c.addFields)
+	kingpin.Flag("move-prefix", "Prefix added by move processor to names of app fields, for example 'app.', app fields colliding with runtime fields (time, stream) are put under it when body is decoded").
+		Default("app.").
+		Envar("MOVE_PREFIX").
+		StringVar(&c.ParserOptions.Move.Prefix)
+	kingpin.Flag("move-exclude", "Field name or glob which is not moved by move processor, can be repeated").
+		Default(parser.DefaultMoveExclude...).
+		Envar("MOVE_EXCLUDE").
+		StringsVar(&c.ParserOptions.Move.Exclude)
+	kingpin.Flag("flatten-separator", "Separator used by flatten processor to join keys of nested objects").
+		Default(".").
+		Envar("FLATTEN_SEPARATOR").
+		StringVar(&c.ParserOptions.Flatten.Separator)
+	kingpin.Flag("flatten-max-depth", "How deep nested objects are flattened, 0 means unlimited").
+		Default("0").
+		Envar("FLATTEN_MAX_DEPTH").
+		IntVar(&c.ParserOptions.Flatten.MaxDepth)
+	kingpin.Flag("flatten-keep-nested", "Keep objects deeper than flatten-max-depth as is instead of dropping them").
+		Envar("FLATTEN_KEEP_NESTED").
+		BoolVar(&c.ParserOptions.Flatten.KeepNested)
+	kingpin.Flag("flatten-arrays", "How flatten processor handles arrays [keep | join | index]").
+		Default("keep").
+		Envar("FLATTEN_ARRAYS").
+		StringVar(&c.ParserOptions.Flatten.Arrays)
+
 	kingpin.Parse()
 
 	if c.includeRegex != "" && c.excludeRegex != "" {
 		log.Fatal("You can not set include and exclude regexs at the same time.")
 	}
+	if c.ReaderOversizedAction != "truncate" && c.ReaderOversizedAction != "drop" {
+		log.Fatalf("Unknown reader-oversized-action '%s'", c.ReaderOversizedAction)
+	}
+	if c.Finder != "files" && c.Finder != "docker-api" {
+		log.Fatalf("Unknown finder '%s'", c.Finder)
+	}
+	if _, err := parser.NewSchema(c.OutputSchema); err != nil {
+		log.Fatal(err)
+	}
+	if c.K8sNodeName == "" {
+		c.K8sNodeName = c.NodeHostname
+	}
 	if c.excludeRegex != "" {
 		c.ExcludeRegex = regexp.MustCompile(c.excludeRegex)
 	}
@@ -133,6 +436,111 @@ func GetConfig() *Config {
 		c.IncludeRegex = regexp.MustCompile(c.includeRegex)
 	}
 
+	for _, rule := range c.multilineRules {
+		r, err := multiline.ParseRule(rule)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.MultilineRules = append(c.MultilineRules, r)
+	}
+
+	for _, rule := range c.bodyFormatRules {
+		r, err := parser.ParseFormatRule(rule)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.BodyFormatRules = append(c.BodyFormatRules, r)
+	}
+
+	c.GrokLibrary = make(map[string]string)
+	for _, p := range c.grokPatterns {
+		field, err := parser.ParseField(p)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.GrokLibrary[field.Key] = field.Value
+	}
+	for _, rule := range c.grokRules {
+		r, err := parser.ParseGrokRule(rule, c.GrokLibrary)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.GrokRules = append(c.GrokRules, r)
+	}
+
+	for _, rule := range c.filterRules {
+		r, err := parser.ParseFilterRule(rule)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.FilterRules = append(c.FilterRules, r)
+	}
+
+	for _, rule := range c.dedupRules {
+		r, err := parser.ParseDedupRule(rule)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.DedupRules = append(c.DedupRules, r)
+	}
+	if _, err := parser.NewDedupProcessor(parser.DedupOptions{Key: c.DedupKey}); err != nil {
+		log.Fatal(err)
+	}
+	for _, rule := range c.rateLimitRules {
+		r, err := ratelimit.ParseRule(rule)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.RateLimitRules = append(c.RateLimitRules, r)
+	}
+	for _, rule := range c.sampleRules {
+		r, err := ratelimit.ParseSampleRule(rule)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.SampleRules = append(c.SampleRules, r)
+	}
+
+	for _, name := range strings.Split(c.processors, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			c.ParserOptions.Processors = append(c.ParserOptions.Processors, name)
+		}
+	}
+	for _, f := range c.renameFields {
+		field, err := parser.ParseField(f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.ParserOptions.Rename = append(c.ParserOptions.Rename, field)
+	}
+	for _, f := range c.addFields {
+		field, err := parser.ParseField(f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.ParserOptions.Add = append(c.ParserOptions.Add, field)
+	}
+	for _, p := range c.redactPatterns {
+		field, err := parser.ParseField(p)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.ParserOptions.Redact.Custom = append(c.ParserOptions.Redact.Custom, field)
+	}
+	if c.coerceDefault {
+		c.coerceRules = append([]string{parser.DefaultCoerceRule}, c.coerceRules...)
+	}
+	for _, rule := range c.coerceRules {
+		r, err := parser.ParseCoerceRule(rule)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.ParserOptions.Coerce = append(c.ParserOptions.Coerce, r)
+	}
+	if _, err := parser.NewProcessors(&c.ParserOptions); err != nil {
+		log.Fatal(err)
+	}
+
 	return c
 }
 
@@ -145,7 +553,12 @@ func (c *Config) ToString() string {
 			if v.Type().Field(i).Name == "RedisPassword" {
 				continue
 			}
-			output += fmt.Sprintf("%s:\t\t'%v'\n", v.Type().Field(i).Name, v.Field(i).Interface())
+			value := v.Field(i).Interface()
+			if o, ok := value.(parser.Options); ok && o.Redact.Key != "" {
+				o.Redact.Key = "***"
+				value = o
+			}
+			output += fmt.Sprintf("%s:\t\t'%v'\n", v.Type().Field(i).Name, value)
 		}
 	}
 	return output
@@ -1,134 +1,106 @@
 package containerd
 
-// ParseLine used to parse docker log string, and try to parse inner log
+// Decoder used to decode CRI (containerd, CRI-O) log lines
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
-	"reflect"
 	"regexp"
 	"rvadim/loggo/pkg/parser"
-	"strconv"
 	"strings"
+	"time"
 )
 
-// Parser parse json and extend it with data
-type Parser struct {
+const (
+	// DefaultPartialMaxBytes default limit of line reassembled from partial (P) entries
+	DefaultPartialMaxBytes = 1024 * 1024
+	// DefaultPartialTimeout default time to wait for the last (F) entry of partial line
+	DefaultPartialTimeout = 5 * time.Second
+)
+
+// Decoder decodes CRI log lines '<time> <stream> <tag> <log>'
+type Decoder struct {
+	offset           int64
 	containerdRegexp *regexp.Regexp
-	properties       parser.Properties
+	partials         *parser.Partials
 }
 
-// New creats new parser
-func New(p parser.Properties) *Parser {
-	return &Parser{
-		containerdRegexp: regexp.MustCompile("(?s)^(.+) (stdout|stderr) . (.*)$"),
-		properties:       p,
+// New creats new parser for CRI logs with default processors
+func New(p parser.Properties) *parser.Parser {
+	return parser.NewPipeline(NewDecoder(DefaultPartialMaxBytes, DefaultPartialTimeout), parser.DefaultProcessors(), p)
+}
+
+// NewDecoder creates new Decoder. Lines split by runtime into partial (P) entries are
+// reassembled, line is sent when the last (F) entry arrived or did not arrive during timeout.
+// Line longer than maxBytes is truncated, 0 disables reassembling.
+func NewDecoder(maxBytes int, timeout time.Duration) *Decoder {
+	d := &Decoder{
+		containerdRegexp: regexp.MustCompile(`(?s)^(\S+) (stdout|stderr) ([PF](?::\S*)?) (.*)$`),
+	}
+	if maxBytes > 0 {
+		d.partials = parser.NewPartials(maxBytes, timeout)
 	}
+	return d
 }
 
-// ParseLine parse JSON string and put all fields to upper json
-func (p *Parser) ParseLine(line string) (string, error) {
-	var i = make(map[string]interface{})
-	output := p.containerdRegexp.FindStringSubmatch(line)
-	if len(output) != 4 {
-		return line, fmt.Errorf("unable to parse containerd line '%s'", line)
+// Decode parses CRI line to record with time, stream and log fields
+func (d *Decoder) Decode(line string) (parser.Properties, error) {
+	var i = make(parser.Properties)
+	output := d.containerdRegexp.FindStringSubmatch(line)
+	if len(output) != 5 {
+		return nil, fmt.Errorf("unable to parse containerd line '%s'", line)
 	}
 	i["time"] = output[1]
 	i["stream"] = output[2]
-	i["log"] = output[3]
-	var inner interface{}
-	err := json.Unmarshal([]byte(output[3]), &inner)
-	if err != nil {
-		out, ierr := p.extend(i)
-		if ierr != nil {
-			return line, ierr
+	i["log"] = output[4]
+	i[parser.OffsetKey] = d.offset
+	if d.partials != nil {
+		partial := isPartial(output[3])
+		if partial {
+			// Newline at the end of partial entry is a line separator in log file, not part of message
+			i["log"] = strings.TrimSuffix(output[4], "\n")
 		}
-		return out, err
+		return d.partials.Join(i, partial), nil
 	}
+	return i, nil
+}
 
-	innerMap, ok := inner.(map[string]interface{})
-	if !ok {
-		out, ierr := p.extend(i)
-		if ierr != nil {
-			return line, ierr
-		}
-		return out, err
+// DecodeTruncated parses the beginning of CRI line, the line is not joined with partial entries
+func (d *Decoder) DecodeTruncated(line string) (parser.Properties, error) {
+	output := d.containerdRegexp.FindStringSubmatch(line)
+	if len(output) != 5 {
+		return nil, fmt.Errorf("unable to parse truncated containerd line")
 	}
-	for key, value := range innerMap {
-		if value == nil {
-			// INFO reflect.TypeOf(nil).Kind() cause panic so check nil here
-			i[key] = nil
-			continue
-		}
-
-		if key == "upstream_response_time" {
-			var floatValue float64
-			var terr = errors.New("Not nil")
-
-			if reflect.TypeOf(value).Kind() == reflect.Float64 {
-				floatValue, terr = value.(float64), nil
-			}
-			if reflect.TypeOf(value).Kind() == reflect.String {
-				floatValue, terr = transformValue(value.(string))
-			}
-
-			if terr == nil {
-				i["upstream_response_time_float"] = floatValue
-			}
-		}
-
-		if reflect.TypeOf(value).Kind() == reflect.Map {
-			if value, ok := value.(map[string]interface{}); ok {
-				for k, v := range value {
-					if v == nil {
-						i[strings.Join([]string{key, k}, ".")] = nil
-						continue
-					}
-					if reflect.TypeOf(v).Kind() == reflect.Map {
-						// We don't want to parse recursive yet
-						continue
-					}
-					i[strings.Join([]string{key, k}, ".")] = v
-				}
-				continue
-			}
-			continue
-		}
+	return parser.Properties{"time": output[1], "stream": output[2], "log": output[4]}, nil
+}
 
-		i[key] = value
+// SetDropOversized makes reassembled lines longer than limit dropped instead of truncated
+func (d *Decoder) SetDropOversized(drop bool) {
+	if d.partials != nil {
+		d.partials.SetDropOversized(drop)
 	}
-	delete(i, "log")
-	out, err := p.extend(i)
-	if err != nil {
-		return line, err
-	}
-	return string(out), nil
 }
 
-func transformValue(value string) (float64, error) {
-	value = strings.Replace(value, " ", "", -1)
-	values := strings.Split(value, ",")
-
-	lastValue := values[len(values)-1]
-	return strconv.ParseFloat(lastValue, 64)
+// SetOffset sets offset of the next decoded line
+func (d *Decoder) SetOffset(offset int64) {
+	d.offset = offset
 }
 
-func (p *Parser) extend(a parser.Properties) (string, error) {
-	for k, v := range p.properties {
-		a[k] = v
-	}
-	out, err := json.Marshal(a)
-	if err != nil {
-		return "", err
+// Held returns the first entries of buffered partial lines
+func (d *Decoder) Held() []parser.Properties {
+	if d.partials == nil {
+		return nil
 	}
-	return string(out), nil
+	return d.partials.Held()
 }
 
-// GetProperty return specifiv extends(key=value parametes) for parsed files
-// For example kubernetes.container_name or kubernetes.namespace
-func (p *Parser) GetProperty(key string) interface{} {
-	if val, ok := p.properties[key]; ok {
-		return val
+// Flush returns partial lines which last (F) entry did not arrive in time, or all of them if force is true
+func (d *Decoder) Flush(force bool) []parser.Properties {
+	if d.partials == nil {
+		return nil
 	}
-	return nil
+	return d.partials.Flush(force)
+}
+
+// isPartial checks CRI tag, tags are separated by ':' and the first one is P or F
+func isPartial(tag string) bool {
+	return strings.SplitN(tag, ":", 2)[0] == "P"
 }
@@ -2,8 +2,10 @@ package containerd
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"rvadim/loggo/pkg/parser"
 )
 
 func TestParseLine(t *testing.T) {
@@ -27,3 +29,92 @@ func TestParseLine(t *testing.T) {
 	assert.Equal(t, `{"log":"I0919 11:57:36.498396       1 binarylog.go:274] rpc: flushed binary log to \"\"\n\n","stream":"stderr","time":"2020-09-19T11:57:36.498638614Z"}`, out)
 
 }
+
+func TestParseLineWithStreamInMessage(t *testing.T) {
+	p := parser.NewPipeline(NewDecoder(1024, time.Hour), []parser.Processor{}, parser.Properties{})
+	out, err := p.ParseLine("2020-09-10T07:00:03.585507743Z stdout F job wrote to stderr P done\n")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"log":"job wrote to stderr P done\n","stream":"stdout","time":"2020-09-10T07:00:03.585507743Z"}`, out)
+
+	out, err = p.ParseLine("2020-09-10T07:00:03.585507743Z stderr P:extra see stdout F below\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "", out)
+	assert.Equal(t, []string{`{"log":"see stdout F below","stream":"stderr","time":"2020-09-10T07:00:03.585507743Z"}`}, p.Flush(true))
+
+	out, err = p.ParseTruncatedLine("2020-09-10T07:00:03.585507743Z stdout F copied from stderr F x", 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"_original_length":1000,"_truncated":true,"log":"copied from stderr F x","stream":"stdout","time":"2020-09-10T07:00:03.585507743Z"}`, out)
+
+	_, err = p.ParseLine("2020-09-10T07:00:03.585507743Z stdout X message\n")
+	assert.Error(t, err)
+}
+
+func TestParsePartial(t *testing.T) {
+	p := New(make(map[string]interface{}))
+	out, err := p.ParseLine("2020-09-10T07:00:03.585507743Z stdout P {\"hello\":\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "", out)
+	out, err = p.ParseLine("2020-09-10T07:00:03.585507744Z stderr F other stream\n")
+	assert.Error(t, err)
+	assert.Equal(t, `{"log":"other stream\n","stream":"stderr","time":"2020-09-10T07:00:03.585507744Z"}`, out)
+	out, err = p.ParseLine("2020-09-10T07:00:03.585507745Z stdout P \"world\",\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "", out)
+	out, err = p.ParseLine("2020-09-10T07:00:03.585507746Z stdout F \"a\":1}\n")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":1,"hello":"world","stream":"stdout","time":"2020-09-10T07:00:03.585507743Z"}`, out)
+
+	// Size limit reached
+	p = parser.NewPipeline(NewDecoder(4, time.Hour), parser.DefaultProcessors(), parser.Properties{})
+	out, _ = p.ParseLine("2020-09-10T07:00:03.585507743Z stdout P ab\n")
+	assert.Equal(t, "", out)
+	out, _ = p.ParseLine("2020-09-10T07:00:03.585507744Z stdout P cd\n")
+	assert.Equal(t, "", out)
+	out, _ = p.ParseLine("2020-09-10T07:00:03.585507745Z stdout P ef\n")
+	assert.Equal(t, "", out)
+	out, _ = p.ParseLine("2020-09-10T07:00:03.585507746Z stdout F g\n")
+	assert.Equal(t, `{"_original_length":8,"_truncated":true,"log":"abcd","stream":"stdout","time":"2020-09-10T07:00:03.585507743Z"}`, out)
+	out, _ = p.ParseLine("2020-09-10T07:00:03.585507747Z stdout F next\n")
+	assert.Equal(t, `{"log":"next\n","stream":"stdout","time":"2020-09-10T07:00:03.585507747Z"}`, out)
+
+	// Oversized line is dropped
+	d := NewDecoder(4, time.Hour)
+	d.SetDropOversized(true)
+	p = parser.NewPipeline(d, parser.DefaultProcessors(), parser.Properties{})
+	out, _ = p.ParseLine("2020-09-10T07:00:03.585507743Z stdout P abc\n")
+	assert.Equal(t, "", out)
+	out, _ = p.ParseLine("2020-09-10T07:00:03.585507744Z stdout F def\n")
+	assert.Equal(t, "", out)
+
+	// Timeout
+	p = parser.NewPipeline(NewDecoder(1024, 10*time.Millisecond), parser.DefaultProcessors(), parser.Properties{})
+	out, _ = p.ParseLine("2020-09-10T07:00:03.585507743Z stdout P ef\n")
+	assert.Equal(t, "", out)
+	assert.Nil(t, p.Flush(false))
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, []string{`{"log":"ef","stream":"stdout","time":"2020-09-10T07:00:03.585507743Z"}`}, p.Flush(false))
+
+	// Reassembling disabled
+	p = parser.NewPipeline(NewDecoder(0, time.Hour), parser.DefaultProcessors(), parser.Properties{})
+	out, _ = p.ParseLine("2020-09-10T07:00:03.585507743Z stdout P gh\n")
+	assert.Equal(t, `{"log":"gh\n","stream":"stdout","time":"2020-09-10T07:00:03.585507743Z"}`, out)
+}
+
+func TestParseCRIOLine(t *testing.T) {
+	p := New(make(map[string]interface{}))
+	out, err := p.ParseLine("2021-03-08T10:21:54.123456789+03:00 stdout P {\"level\":\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "", out)
+	out, err = p.ParseLine("2021-03-08T10:21:54.223456789+03:00 stdout F \"info\"}\n")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"level":"info","stream":"stdout","time":"2021-03-08T10:21:54.123456789+03:00"}`, out)
+}
+
+func TestParseTruncatedLine(t *testing.T) {
+	p := parser.NewPipeline(NewDecoder(DefaultPartialMaxBytes, DefaultPartialTimeout), []parser.Processor{}, parser.Properties{})
+	out, err := p.ParseTruncatedLine("2020-09-10T07:00:03.585507743Z stderr F aaaaa", 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"_original_length":1000,"_truncated":true,"log":"aaaaa","stream":"stderr","time":"2020-09-10T07:00:03.585507743Z"}`, out)
+	_, err = p.ParseTruncatedLine("2020-09-10T07:00:03", 1000)
+	assert.Error(t, err)
+}
@@ -0,0 +1,75 @@
+package delivery
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"rvadim/loggo/pkg/config"
+)
+
+const (
+	// DefaultInitialDelay used when Backoff created with zero initial delay
+	DefaultInitialDelay = 500 * time.Millisecond
+	// DefaultMaxDelay used when Backoff created with zero max delay
+	DefaultMaxDelay = time.Minute
+	// DefaultMultiplier used when Backoff created with multiplier less than 1
+	DefaultMultiplier = 2
+)
+
+// Backoff computes exponentially growing delay between delivery attempts.
+// Jitter is a fraction of delay which is randomly subtracted from it,
+// so readers failed at the same moment do not retry at the same moment.
+type Backoff struct {
+	initial    time.Duration
+	max        time.Duration
+	multiplier float64
+	jitter     float64
+}
+
+// NewBackoff creates new Backoff, zero values replaced by defaults
+func NewBackoff(initial time.Duration, max time.Duration, multiplier float64, jitter float64) *Backoff {
+	if initial <= 0 {
+		initial = DefaultInitialDelay
+	}
+	if max <= 0 {
+		max = DefaultMaxDelay
+	}
+	if max < initial {
+		max = initial
+	}
+	if multiplier < 1 {
+		multiplier = DefaultMultiplier
+	}
+	if jitter < 0 {
+		jitter = 0
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+	return &Backoff{
+		initial:    initial,
+		max:        max,
+		multiplier: multiplier,
+		jitter:     jitter,
+	}
+}
+
+// NewBackoffFromConfig creates new Backoff configured by options
+func NewBackoffFromConfig(c *config.Config) *Backoff {
+	return NewBackoff(time.Duration(c.BackoffInitialMs)*time.Millisecond,
+		time.Duration(c.BackoffMaxSec)*time.Second, c.BackoffMultiplier, c.BackoffJitter)
+}
+
+// Delay returns delay before attempt number attempt (starts from 1)
+func (b *Backoff) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := float64(b.initial) * math.Pow(b.multiplier, float64(attempt-1))
+	if delay > float64(b.max) || math.IsInf(delay, 0) {
+		delay = float64(b.max)
+	}
+	delay -= delay * b.jitter * rand.Float64()
+	return time.Duration(delay)
+}
@@ -0,0 +1,183 @@
+package delivery
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"rvadim/loggo/pkg/metrics"
+	"rvadim/loggo/pkg/transport"
+)
+
+// Circuit breaker states, also used as values of breaker_state metric
+const (
+	StateClosed   = 0
+	StateOpen     = 1
+	StateHalfOpen = 2
+)
+
+// ErrBreakerOpen returned by DeliverMessages while breaker is open
+var ErrBreakerOpen = errors.New("circuit breaker is open")
+
+// Breaker is a circuit breaker around transport, shared by all readers.
+// After threshold consecutive failures breaker opens and rejects deliveries
+// without touching transport. When open period (computed by backoff) expires,
+// one trial delivery is allowed, its result closes or opens breaker again.
+type Breaker struct {
+	name      string
+	t         transport.ITransportClient
+	threshold int
+	backoff   *Backoff
+	mu        sync.Mutex
+	state     int
+	failures  int
+	opens     int
+	until     time.Time
+	trial     bool
+	changed   chan bool
+}
+
+// NewBreaker wraps transport t with circuit breaker, name used in logs and metrics
+func NewBreaker(name string, t transport.ITransportClient, threshold int, backoff *Backoff) *Breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	b := &Breaker{
+		name:      name,
+		t:         t,
+		threshold: threshold,
+		backoff:   backoff,
+		changed:   make(chan bool),
+	}
+	metrics.BreakerState.WithLabelValues(name).Set(StateClosed)
+	return b
+}
+
+// DeliverMessages delivers data with underlying transport if breaker allows it
+func (b *Breaker) DeliverMessages(data []string) error {
+	if !b.allow() {
+		return ErrBreakerOpen
+	}
+	err := b.t.DeliverMessages(data)
+	b.record(err)
+	return err
+}
+
+// Close closes underlying transport
+func (b *Breaker) Close() error {
+	return b.t.Close()
+}
+
+// HealthCheck checks health of underlying transport
+func (b *Breaker) HealthCheck() error {
+	return transport.HealthCheck(b.t)
+}
+
+// State returns current breaker state
+func (b *Breaker) State() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Wait parks caller while breaker is open.
+// Returns false if stop channel was closed during waiting.
+func (b *Breaker) Wait(stop <-chan bool) bool {
+	for {
+		b.mu.Lock()
+		if b.state == StateOpen && !time.Now().Before(b.until) {
+			b.setState(StateHalfOpen)
+		}
+		if b.state == StateClosed || (b.state == StateHalfOpen && !b.trial) {
+			b.mu.Unlock()
+			return true
+		}
+		var timer <-chan time.Time
+		if b.state == StateOpen {
+			timer = time.After(time.Until(b.until))
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-stop:
+			return false
+		case <-changed:
+		case <-timer:
+		}
+	}
+}
+
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateOpen && !time.Now().Before(b.until) {
+		b.setState(StateHalfOpen)
+	}
+	switch b.state {
+	case StateClosed:
+		return true
+	case StateHalfOpen:
+		if !b.trial {
+			b.trial = true
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures = 0
+		b.opens = 0
+		if b.state != StateClosed {
+			log.Printf("Circuit breaker for '%s' closed, delivery restored", b.name)
+			b.setState(StateClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == StateOpen {
+		// Delivery started before breaker opened, nothing to change
+		return
+	}
+	if b.state == StateHalfOpen || b.failures >= b.threshold {
+		b.opens++
+		b.until = time.Now().Add(b.backoff.Delay(b.opens))
+		log.Printf("Circuit breaker for '%s' opened till %s, due to %s", b.name, b.until.Format(time.RFC3339), err)
+		b.setState(StateOpen)
+	}
+}
+
+// setState must be called with locked mutex
+func (b *Breaker) setState(state int) {
+	b.trial = false
+	if b.state == state {
+		return
+	}
+	b.state = state
+	close(b.changed)
+	b.changed = make(chan bool)
+	metrics.BreakerState.WithLabelValues(b.name).Set(float64(state))
+}
+
+type waiter interface {
+	Wait(stop <-chan bool) bool
+}
+
+// Wait sleeps for delay, then parks while breaker of transport t (if any) is open.
+// Returns false if stop channel was closed during waiting.
+func Wait(t transport.ITransportClient, delay time.Duration, stop <-chan bool) bool {
+	select {
+	case <-stop:
+		return false
+	case <-time.After(delay):
+	}
+	if w, ok := t.(waiter); ok {
+		return w.Wait(stop)
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package delivery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"rvadim/loggo/pkg/tests"
+)
+
+func TestBackoff(t *testing.T) {
+	b := NewBackoff(100*time.Millisecond, time.Second, 2, 0)
+	assert.Equal(t, 100*time.Millisecond, b.Delay(1))
+	assert.Equal(t, 200*time.Millisecond, b.Delay(2))
+	assert.Equal(t, 800*time.Millisecond, b.Delay(4))
+	assert.Equal(t, time.Second, b.Delay(5))
+	assert.Equal(t, time.Second, b.Delay(10000))
+
+	b = NewBackoff(0, 0, 0, 0)
+	assert.Equal(t, DefaultInitialDelay, b.Delay(1))
+	assert.Equal(t, DefaultMaxDelay, b.Delay(100))
+
+	b = NewBackoff(100*time.Millisecond, time.Second, 2, 0.5)
+	for i := 0; i < 100; i++ {
+		delay := b.Delay(2)
+		assert.True(t, delay <= 200*time.Millisecond && delay >= 100*time.Millisecond, delay)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	transport := &tests.TransportMock{}
+	transport.SetBroken(true)
+	b := NewBreaker("test", transport, 2, NewBackoff(50*time.Millisecond, time.Second, 2, 0))
+
+	assert.Error(t, b.DeliverMessages([]string{"a"}))
+	assert.Equal(t, StateClosed, b.State())
+	assert.Error(t, b.DeliverMessages([]string{"a"}))
+	assert.Equal(t, StateOpen, b.State())
+	assert.Equal(t, ErrBreakerOpen, b.DeliverMessages([]string{"a"}))
+
+	stop := make(chan bool)
+	start := time.Now()
+	assert.True(t, b.Wait(stop))
+	assert.True(t, time.Since(start) >= 40*time.Millisecond)
+	assert.Equal(t, StateHalfOpen, b.State())
+
+	// Failed trial opens breaker for longer period
+	assert.Error(t, b.DeliverMessages([]string{"a"}))
+	assert.Equal(t, StateOpen, b.State())
+	close(stop)
+	assert.False(t, b.Wait(stop))
+
+	time.Sleep(110 * time.Millisecond)
+	transport.SetBroken(false)
+	assert.NoError(t, b.DeliverMessages([]string{"b"}))
+	assert.Equal(t, StateClosed, b.State())
+	assert.Equal(t, []string{"b"}, transport.GetMessages())
+	assert.NoError(t, b.Close())
+	assert.True(t, transport.GetClosed())
+}
+
+func TestWait(t *testing.T) {
+	stop := make(chan bool)
+	assert.True(t, Wait(&tests.TransportMock{}, time.Millisecond, stop))
+	close(stop)
+	assert.False(t, Wait(&tests.TransportMock{}, time.Second, stop))
+}
@@ -0,0 +1,260 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSocketPath where Docker Engine API listens
+const DefaultSocketPath = "/var/run/docker.sock"
+
+// requestTimeout limits time of listing and inspecting containers, so start is not blocked by hung daemon
+const requestTimeout = 30 * time.Second
+
+// labelDockerType marks pod sandbox (pause) containers created by kubelet, they have no logs
+const (
+	labelDockerType   = "io.kubernetes.docker.type"
+	dockerTypeSandbox = "podsandbox"
+)
+
+// apiEvent is Docker Engine API event, old API versions set only id and status
+type apiEvent struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Status string `json:"status"`
+	ID     string `json:"id"`
+	Actor  struct {
+		ID string `json:"ID"`
+	} `json:"Actor"`
+}
+
+// APIFinder finds containers through Docker Engine API on unix socket instead of reading
+// their configuration from disk. Containers are listed once and then updated by events,
+// container stays in list after it dies until it is destroyed, so its log is read till the end.
+type APIFinder struct {
+	client     *http.Client
+	url        string
+	retry      time.Duration
+	timeout    time.Duration
+	mu         sync.RWMutex
+	containers map[string]*Container
+	synced     time.Time
+}
+
+// NewAPIFinder creates new APIFinder for Docker Engine API listening on socket
+func NewAPIFinder(socket string) *APIFinder {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}}
+	return &APIFinder{
+		client:     client,
+		url:        "http://docker",
+		retry:      5 * time.Second,
+		timeout:    requestTimeout,
+		containers: make(map[string]*Container),
+	}
+}
+
+// GetAllContainers returns containers known from API, containers are listed if it is not done yet
+func (f *APIFinder) GetAllContainers() ([]*Container, error) {
+	f.mu.RLock()
+	synced := !f.synced.IsZero()
+	f.mu.RUnlock()
+	if !synced {
+		if err := f.Sync(); err != nil {
+			return nil, err
+		}
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	containers := make([]*Container, 0, len(f.containers))
+	for _, c := range f.containers {
+		containers = append(containers, c)
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].ID < containers[j].ID
+	})
+	return containers, nil
+}
+
+// Sync lists all containers and replaces known containers with them
+func (f *APIFinder) Sync() error {
+	started := time.Now()
+	var list []struct {
+		ID string `json:"Id"`
+	}
+	if err := f.get(context.Background(), "/containers/json?all=true", &list); err != nil {
+		return err
+	}
+	containers := make(map[string]*Container, len(list))
+	for _, item := range list {
+		c, err := f.inspect(context.Background(), item.ID)
+		if err != nil {
+			// Container may be removed after it is listed
+			log.Printf("Error: unable to inspect container %s, %s", item.ID, err)
+			continue
+		}
+		if c != nil {
+			containers[c.ID] = c
+		}
+	}
+	f.mu.Lock()
+	f.containers, f.synced = containers, started
+	f.mu.Unlock()
+	return nil
+}
+
+// Run updates containers by start, die and destroy events until stop channel closed,
+// containers are listed again if events stream fails
+func (f *APIFinder) Run(stop <-chan bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	for {
+		f.mu.RLock()
+		synced := f.synced
+		f.mu.RUnlock()
+		var err error
+		if synced.IsZero() {
+			err = f.Sync()
+		} else {
+			err = f.watch(ctx, synced)
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if err == nil {
+			continue
+		}
+		log.Printf("Error: unable to watch docker events, %s", err)
+		f.mu.Lock()
+		f.synced = time.Time{}
+		f.mu.Unlock()
+		select {
+		case <-stop:
+			return
+		case <-time.After(f.retry):
+		}
+	}
+}
+
+// watch applies container events since time of the last listing
+func (f *APIFinder) watch(ctx context.Context, since time.Time) error {
+	filters, _ := json.Marshal(map[string][]string{
+		"type":  {"container"},
+		"event": {"start", "die", "destroy"},
+	})
+	query := url.Values{}
+	query.Set("since", fmt.Sprint(since.Unix()))
+	query.Set("filters", string(filters))
+	resp, err := f.do(ctx, "/events?"+query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event apiEvent
+		if err := decoder.Decode(&event); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err == io.EOF {
+				return fmt.Errorf("events stream closed")
+			}
+			return fmt.Errorf("unable to decode event, %w", err)
+		}
+		if event.Type != "" && event.Type != "container" {
+			continue
+		}
+		id, action := event.Actor.ID, event.Action
+		if id == "" {
+			id = event.ID
+		}
+		if action == "" {
+			action = event.Status
+		}
+		switch action {
+		case "start", "die":
+			c, err := f.inspect(ctx, id)
+			if err != nil {
+				log.Printf("Error: unable to inspect container %s, %s", id, err)
+				continue
+			}
+			if c != nil {
+				f.mu.Lock()
+				f.containers[c.ID] = c
+				f.mu.Unlock()
+			}
+		case "destroy":
+			f.mu.Lock()
+			delete(f.containers, id)
+			f.mu.Unlock()
+		}
+	}
+}
+
+// inspect returns container by id, nil if container has no log file (logging driver is not json-file)
+// or it is pod sandbox
+func (f *APIFinder) inspect(ctx context.Context, id string) (*Container, error) {
+	c := &Container{}
+	if err := f.get(ctx, "/containers/"+url.PathEscape(id)+"/json", c); err != nil {
+		return nil, err
+	}
+	if c.LogPath == "" || c.getLabelValue(labelDockerType) == dockerTypeSandbox {
+		return nil, nil
+	}
+	c.CRIType = CRI_TYPE_DOCKER
+	return c, nil
+}
+
+// get requests path and decodes response, request is limited by timeout
+func (f *APIFinder) get(ctx context.Context, path string, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	resp, err := f.do(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("unable to decode response of %s, %w", path, err)
+	}
+	return nil
+}
+
+func (f *APIFinder) do(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %d of %s: %s", resp.StatusCode, path, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
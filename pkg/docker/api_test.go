@@ -0,0 +1,169 @@
+package docker
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"rvadim/loggo/pkg/k8s"
+)
+
+// fakeDockerAPI serves containers and events of Docker Engine API
+type fakeDockerAPI struct {
+	mu         sync.Mutex
+	containers map[string]string
+	events     chan string
+}
+
+func (a *fakeDockerAPI) set(id string, logPath string, labels string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.containers[id] = fmt.Sprintf(`{"Id":"%s","LogPath":"%s","Config":{"Labels":{%s}}}`, id, logPath, labels)
+}
+
+func (a *fakeDockerAPI) remove(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.containers, id)
+}
+
+func (a *fakeDockerAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case r.URL.Path == "/containers/json":
+		var items []string
+		for id := range a.containers {
+			items = append(items, fmt.Sprintf(`{"Id":"%s"}`, id))
+		}
+		fmt.Fprintf(w, "[%s]", strings.Join(items, ","))
+	case strings.HasPrefix(r.URL.Path, "/containers/"):
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/containers/"), "/json")
+		container, ok := a.containers[id]
+		if !ok {
+			http.Error(w, `{"message":"No such container"}`, http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, container)
+	case r.URL.Path == "/events":
+		a.mu.Unlock()
+		defer a.mu.Lock()
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case event := <-a.events:
+				fmt.Fprintln(w, event)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestAPIFinder(t *testing.T) {
+	testSocket := t.TempDir() + "/docker.sock"
+	listener, err := net.Listen("unix", testSocket)
+	assert.NoError(t, err)
+	api := &fakeDockerAPI{containers: make(map[string]string), events: make(chan string)}
+	server := &http.Server{Handler: api}
+	go server.Serve(listener)
+	defer server.Close()
+
+	labels := fmt.Sprintf(`"%s":"default","%s":"web-1","%s":"nginx"`,
+		k8s.LabelKubernetesPodNamespace, k8s.LabelKubernetesPodName, k8s.LabelKubernetesContainerName)
+	api.set("a1", "/var/lib/docker/containers/a1/a1-json.log", labels)
+	api.set("p1", "/var/lib/docker/containers/p1/p1-json.log", `"io.kubernetes.docker.type":"podsandbox"`)
+	api.set("j1", "", labels)
+
+	f := NewAPIFinder(testSocket)
+	f.retry = 10 * time.Millisecond
+	containers, err := f.GetAllContainers()
+	assert.NoError(t, err)
+	assert.Equal(t, []*Container{{
+		ID:      "a1",
+		LogPath: "/var/lib/docker/containers/a1/a1-json.log",
+		Config: ConfigSection{Labels: map[string]string{
+			k8s.LabelKubernetesPodNamespace:  "default",
+			k8s.LabelKubernetesPodName:       "web-1",
+			k8s.LabelKubernetesContainerName: "nginx",
+		}},
+		CRIType: CRI_TYPE_DOCKER,
+	}}, containers)
+	assert.Equal(t, "web-1", containers[0].GetPodName())
+
+	stop := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		f.Run(stop)
+		close(done)
+	}()
+	ids := func() []string {
+		containers, _ := f.GetAllContainers()
+		var ids []string
+		for _, c := range containers {
+			ids = append(ids, c.ID)
+		}
+		return ids
+	}
+
+	api.set("b2", "/var/lib/docker/containers/b2/b2-json.log", labels)
+	api.events <- `{"Type":"container","Action":"start","Actor":{"ID":"b2"}}`
+	assert.Eventually(t, func() bool { return assert.ObjectsAreEqual([]string{"a1", "b2"}, ids()) },
+		time.Second, 10*time.Millisecond)
+
+	// Dead container is kept till it is destroyed, so the rest of its log is read
+	api.events <- `{"Type":"container","Action":"die","Actor":{"ID":"a1"}}`
+	assert.Never(t, func() bool { return !assert.ObjectsAreEqual([]string{"a1", "b2"}, ids()) },
+		100*time.Millisecond, 10*time.Millisecond)
+	api.remove("a1")
+	api.events <- `{"status":"destroy","id":"a1"}`
+	assert.Eventually(t, func() bool { return assert.ObjectsAreEqual([]string{"b2"}, ids()) },
+		time.Second, 10*time.Millisecond)
+
+	// Broken events stream causes containers to be listed again
+	api.set("c3", "/var/lib/docker/containers/c3/c3-json.log", labels)
+	api.events <- `not json`
+	assert.Eventually(t, func() bool { return assert.ObjectsAreEqual([]string{"b2", "c3"}, ids()) },
+		time.Second, 10*time.Millisecond)
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("finder is not stopped")
+	}
+}
+
+func TestAPIFinderUnavailable(t *testing.T) {
+	f := NewAPIFinder(t.TempDir() + "/missing.sock")
+	_, err := f.GetAllContainers()
+	assert.Error(t, err)
+}
+
+func TestAPIFinderTimeout(t *testing.T) {
+	socket := t.TempDir() + "/docker.sock"
+	listener, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+	// Daemon accepts connection and does not respond
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	f := NewAPIFinder(socket)
+	f.timeout = 50 * time.Millisecond
+	started := time.Now()
+	_, err = f.GetAllContainers()
+	assert.Error(t, err)
+	assert.True(t, time.Since(started) < time.Second)
+}
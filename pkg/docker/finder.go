@@ -24,10 +24,20 @@ import (
 //    * kubernetes.namespace_name   Config.Labels."io.kubernetes.pod.namespace"
 //    * kubernetes.container_name   Config.Labels."io.kubernetes.container.name"
 
+// CRI-O stores containers configuration in <crio storage path>/<container id>/userdata/config.json,
+// annotation 'io.kubernetes.cri-o.LogPath' of configuration points to container log file.
+// Log files of CRI-O and containerd are regular files in the same directory structure,
+// so log file is considered as CRI-O one if CRI-O configuration points to it.
+
 const (
 	configFileName      = "config.v2.json"
+	crioConfigFileName  = "config.json"
+	crioLogPathKey      = "io.kubernetes.cri-o.LogPath"
 	CRI_TYPE_CONTAINERD = 0
 	CRI_TYPE_DOCKER     = 1
+	CRI_TYPE_CRIO       = 2
+	// DefaultCRIOStoragePath where CRI-O stores containers configuration
+	DefaultCRIOStoragePath = "/var/lib/containers/storage/overlay-containers"
 )
 
 // Container store container configuration parameters
@@ -45,8 +55,10 @@ type ConfigSection struct {
 
 // Finder seek for logs in requested logPath and resolve links
 type Finder struct {
-	logsPath string
-	mu       sync.Mutex
+	logsPath        string
+	crioStoragePath string
+	crioContainers  map[string]string
+	mu              sync.Mutex
 }
 
 // GetPodName returns container pod name or empty string
@@ -59,6 +71,11 @@ func (c *Container) GetPodNamespace() string {
 	return c.getLabelValue(k8s.LabelKubernetesPodNamespace)
 }
 
+// GetPodUID returns container pod uid or empty string
+func (c *Container) GetPodUID() string {
+	return c.getLabelValue(k8s.LabelKubernetesPodUID)
+}
+
 // GetName returns container name or empty string
 func (c *Container) GetName() string {
 	return c.getLabelValue(k8s.LabelKubernetesContainerName)
@@ -80,10 +97,17 @@ func NewFinder(path string) (*Finder, error) {
 	}
 	log.Printf("Absolute path for finder '%s'", absPath)
 	return &Finder{
-		logsPath: absPath,
+		logsPath:        absPath,
+		crioStoragePath: DefaultCRIOStoragePath,
+		crioContainers:  make(map[string]string),
 	}, nil
 }
 
+// SetCRIOStoragePath sets path where CRI-O stores containers configuration
+func (f *Finder) SetCRIOStoragePath(path string) {
+	f.crioStoragePath = path
+}
+
 // GetAllContainers seek and return all Containers
 func (f *Finder) GetAllContainers() ([]*Container, error) {
 	var containers []*Container
@@ -93,6 +117,7 @@ func (f *Finder) GetAllContainers() ([]*Container, error) {
 		log.Printf("Unable to get all directories in '%s', due to '%s'", f.logsPath, err)
 		return containers, err
 	}
+	crioLogs := f.getCRIOLogPaths()
 	for _, subdir := range dirs {
 		subdirs, err := getAllDirectories(subdir)
 		if err != nil {
@@ -127,6 +152,10 @@ func (f *Finder) GetAllContainers() ([]*Container, error) {
 						continue
 					}
 					container.CRIType = CRI_TYPE_CONTAINERD
+					if id, ok := crioLogs[file]; ok {
+						container.CRIType = CRI_TYPE_CRIO
+						container.ID = id
+					}
 				}
 				if !strings.HasSuffix(container.LogPath, ".log") {
 					// Skip non-log files
@@ -158,11 +187,59 @@ func (f *Finder) buildContainerDContainerLog(file string) (*Container, error) {
 				k8s.LabelKubernetesPodName:       pod,
 				k8s.LabelKubernetesPodNamespace:  namespace,
 				k8s.LabelKubernetesContainerName: containerName,
+				k8s.LabelKubernetesPodUID:        id,
 			},
 		},
 	}, nil
 }
 
+// getCRIOLogPaths returns map of log file path to CRI-O container id,
+// map is empty if CRI-O storage is not present on node
+func (f *Finder) getCRIOLogPaths() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids, err := getAllDirectories(f.crioStoragePath)
+	if err != nil {
+		return map[string]string{}
+	}
+	// Configuration of container never changes, so read only new ones
+	actual := make(map[string]string, len(ids))
+	for _, dir := range ids {
+		id := filepath.Base(dir)
+		if logPath, ok := f.crioContainers[id]; ok {
+			actual[id] = logPath
+			continue
+		}
+		logPath, err := getCRIOLogPath(filepath.Join(dir, "userdata", crioConfigFileName))
+		if err != nil {
+			log.Printf("Error: unable to read CRI-O container config %s, %s", dir, err)
+			continue
+		}
+		actual[id] = logPath
+	}
+	f.crioContainers = actual
+	output := make(map[string]string, len(actual))
+	for id, logPath := range actual {
+		output[logPath] = id
+	}
+	return output
+}
+
+func getCRIOLogPath(configPath string) (string, error) {
+	config := struct {
+		Annotations map[string]string `json:"annotations"`
+	}{}
+	data, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return "", err
+	}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Clean(config.Annotations[crioLogPathKey]), nil
+}
+
 func (f *Finder) buildDockerContainerLog(link string) (*Container, error) {
 	path, err := f.resolveSymlink(link)
 	if err != nil {
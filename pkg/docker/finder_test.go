@@ -32,10 +32,11 @@ type TestContainer struct {
 }
 
 type testEnvironment struct {
-	container TestContainer
-	podsEnv   podsEnv
-	dockerEnv dockerEnv
-	tempDir   string
+	container   TestContainer
+	podsEnv     podsEnv
+	dockerEnv   dockerEnv
+	crioStorage string
+	tempDir     string
 }
 
 func setUp(t *testing.T, envType string) testEnvironment {
@@ -97,6 +98,16 @@ func setUp(t *testing.T, envType string) testEnvironment {
 		ioutil.WriteFile(filepath.Join(te.podsEnv.containerDir, te.podsEnv.fileName), logContent, 0700)
 	}
 
+	te.crioStorage = filepath.Join(te.tempDir, "overlay-containers")
+	if envType == "crio" {
+		userdataDir := filepath.Join(te.crioStorage, "8a2bd1dbf1c3", "userdata")
+		err = os.MkdirAll(userdataDir, 0755)
+		assert.NoError(t, err)
+		configContent := []byte(fmt.Sprintf(`{"annotations":{"io.kubernetes.cri-o.LogPath":"%s"}}`,
+			filepath.Join(te.podsEnv.containerDir, te.podsEnv.fileName)))
+		ioutil.WriteFile(filepath.Join(userdataDir, crioConfigFileName), configContent, 0700)
+	}
+
 	return te
 }
 
@@ -165,6 +176,7 @@ func TestLogsFinderContainerD(t *testing.T) {
 
 	f, err := NewFinder(te.podsEnv.rootDir)
 	assert.NoError(t, err)
+	f.SetCRIOStoragePath(te.crioStorage)
 
 	containers, err := f.GetAllContainers()
 	assert.NoError(t, err)
@@ -176,3 +188,28 @@ func TestLogsFinderContainerD(t *testing.T) {
 	assert.Equal(t, te.container.containerID, c.ID)
 	assert.Equal(t, CRI_TYPE_CONTAINERD, c.CRIType)
 }
+
+func TestLogsFinderCRIO(t *testing.T) {
+	te := setUp(t, "crio")
+	defer tearDown(te)
+
+	f, err := NewFinder(te.podsEnv.rootDir)
+	assert.NoError(t, err)
+	f.SetCRIOStoragePath(te.crioStorage)
+
+	containers, err := f.GetAllContainers()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(containers))
+	c := containers[0]
+	assert.Equal(t, te.container.containerName, c.GetName())
+	assert.Equal(t, te.container.podName, c.GetPodName())
+	assert.Equal(t, te.container.namespace, c.GetPodNamespace())
+	assert.Equal(t, "8a2bd1dbf1c3", c.ID)
+	assert.Equal(t, CRI_TYPE_CRIO, c.CRIType)
+
+	// Cached configuration is dropped with container
+	os.RemoveAll(te.crioStorage)
+	containers, err = f.GetAllContainers()
+	assert.NoError(t, err)
+	assert.Equal(t, CRI_TYPE_CONTAINERD, containers[0].CRIType)
+}
@@ -0,0 +1,97 @@
+package health
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"rvadim/loggo/pkg/delivery"
+	"rvadim/loggo/pkg/metrics"
+	"rvadim/loggo/pkg/transport"
+)
+
+// WaitHealthy checks transport health at startup, failed check retried with
+// backoff till tries exhausted, last error returned in that case
+func WaitHealthy(t transport.ITransportClient, tries int, backoff *delivery.Backoff) error {
+	var err error
+	for attempt := 1; attempt <= tries; attempt++ {
+		err = transport.HealthCheck(t)
+		if err == nil {
+			return nil
+		}
+		if attempt < tries {
+			delay := backoff.Delay(attempt)
+			log.Printf("Try #%d, transport health check failed: %s, retry after %s", attempt, err, delay)
+			time.Sleep(delay)
+		}
+	}
+	return fmt.Errorf("transport is not healthy after %d tries, %w", tries, err)
+}
+
+// Checker periodically checks transport health and reports it on readiness endpoint
+type Checker struct {
+	t        transport.ITransportClient
+	interval time.Duration
+	mu       sync.Mutex
+	err      error
+	checked  time.Time
+}
+
+// NewChecker creates new Checker, transport considered healthy until the first check
+func NewChecker(t transport.ITransportClient, interval time.Duration) *Checker {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	return &Checker{
+		t:        t,
+		interval: interval,
+	}
+}
+
+// Run checks transport health each interval until stop channel closed
+func (c *Checker) Run(stop <-chan bool) {
+	for {
+		c.Check()
+		select {
+		case <-stop:
+			return
+		case <-time.After(c.interval):
+		}
+	}
+}
+
+// Check runs health check and stores its result
+func (c *Checker) Check() error {
+	err := transport.HealthCheck(c.t)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil && c.err == nil {
+		log.Printf("Transport health check failed: %s", err)
+	}
+	if err == nil && c.err != nil {
+		log.Println("Transport health check succeeded, transport is healthy again")
+	}
+	c.err = err
+	c.checked = time.Now()
+	if err != nil {
+		metrics.TransportHealthy.Set(0)
+	} else {
+		metrics.TransportHealthy.Set(1)
+	}
+	return err
+}
+
+// ServeHTTP responds 200 if the last health check succeeded and 503 otherwise
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	err, checked := c.err, c.checked
+	c.mu.Unlock()
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "transport is not healthy since %s: %s\n", checked.Format(time.RFC3339), err)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,48 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"rvadim/loggo/pkg/delivery"
+	"rvadim/loggo/pkg/tests"
+)
+
+func TestWaitHealthy(t *testing.T) {
+	backoff := delivery.NewBackoff(time.Millisecond, 0, 1, 0)
+	transport := &tests.TransportMock{}
+	assert.NoError(t, WaitHealthy(transport, 3, backoff))
+
+	transport.SetBroken(true)
+	assert.Error(t, WaitHealthy(transport, 3, backoff))
+
+	// Transport without health check considered healthy
+	assert.NoError(t, WaitHealthy(&tests.RedisClientMock{}, 1, backoff))
+}
+
+func TestChecker(t *testing.T) {
+	transport := &tests.TransportMock{}
+	c := NewChecker(transport, time.Second)
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	transport.SetBroken(true)
+	assert.Error(t, c.Check())
+	rec = httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "connection refused")
+
+	transport.SetBroken(false)
+	stop := make(chan bool)
+	close(stop)
+	c.Run(stop)
+	rec = httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
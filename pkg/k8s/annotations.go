@@ -0,0 +1,31 @@
+package k8s
+
+// Pod annotations overriding configuration for pod containers, annotation with
+// container name suffix '<annotation>.<container>' applies to that container only.
+// Exclude and route are applied to running readers when pod is changed, other annotations
+// are read when reader is spawned, so their changes apply after container restart.
+const (
+	// AnnotationExclude disables reading logs if set to true, other values are ignored
+	AnnotationExclude = "loggo.io/exclude"
+	// AnnotationParser sets format of log body parsed by body processor [json | logfmt | auto | nginx | apache | klog]
+	AnnotationParser = "loggo.io/parser"
+	// AnnotationMultilinePattern sets pattern of the first line of multiline event
+	AnnotationMultilinePattern = "loggo.io/multiline-pattern"
+	// AnnotationRoute sets logstash prefix which routes log messages to index
+	AnnotationRoute = "loggo.io/route"
+	// AnnotationSampleRate sets part of log messages kept, between 0 and 1
+	AnnotationSampleRate = "loggo.io/sample-rate"
+)
+
+// Annotation returns value of annotation for container, annotation for container
+// takes precedence over annotation for all pod containers
+func (p *Pod) Annotation(name string, container string) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	if value, ok := p.Annotations[name+"."+container]; ok {
+		return value, true
+	}
+	value, ok := p.Annotations[name]
+	return value, ok
+}
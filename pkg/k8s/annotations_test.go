@@ -0,0 +1,29 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPodAnnotation(t *testing.T) {
+	pod := &Pod{Annotations: map[string]string{
+		AnnotationParser:                   "logfmt",
+		AnnotationParser + ".nginx":        "nginx",
+		AnnotationExclude + ".istio-proxy": "true",
+	}}
+	value, ok := pod.Annotation(AnnotationParser, "app")
+	assert.True(t, ok)
+	assert.Equal(t, "logfmt", value)
+	value, _ = pod.Annotation(AnnotationParser, "nginx")
+	assert.Equal(t, "nginx", value)
+	value, ok = pod.Annotation(AnnotationExclude, "istio-proxy")
+	assert.True(t, ok)
+	assert.Equal(t, "true", value)
+	_, ok = pod.Annotation(AnnotationExclude, "app")
+	assert.False(t, ok)
+
+	var unknown *Pod
+	_, ok = unknown.Annotation(AnnotationParser, "app")
+	assert.False(t, ok)
+}
@@ -7,4 +7,6 @@ const (
 	LabelKubernetesPodName = "io.kubernetes.pod.name"
 	//LabelKubernetesContainerName store label name of container
 	LabelKubernetesContainerName = "io.kubernetes.container.name"
+	//LabelKubernetesPodUID store label name of pod uid
+	LabelKubernetesPodUID = "io.kubernetes.pod.uid"
 )
@@ -0,0 +1,367 @@
+package k8s
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Service account files mounted into pod running in cluster
+const (
+	serviceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCA    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// watchTimeoutSec asks API server to close watch after that time, watch is restarted from last version
+const watchTimeoutSec = 300
+
+// listTimeout limits time of listing pods, so start is not blocked by unavailable API server
+const listTimeout = 30 * time.Second
+
+// Pod store pod metadata attached to records of its containers
+type Pod struct {
+	UID         string
+	Namespace   string
+	Name        string
+	Labels      map[string]string
+	Annotations map[string]string
+	OwnerKind   string
+	OwnerName   string
+	PodIP       string
+	// Images are container images by container name
+	Images map[string]string
+}
+
+// apiPod is part of Kubernetes Pod object used by provider
+type apiPod struct {
+	Metadata struct {
+		UID             string            `json:"uid"`
+		Namespace       string            `json:"namespace"`
+		Name            string            `json:"name"`
+		ResourceVersion string            `json:"resourceVersion"`
+		Labels          map[string]string `json:"labels"`
+		Annotations     map[string]string `json:"annotations"`
+		OwnerReferences []struct {
+			Kind       string `json:"kind"`
+			Name       string `json:"name"`
+			Controller bool   `json:"controller"`
+		} `json:"ownerReferences"`
+	} `json:"metadata"`
+	Spec struct {
+		InitContainers []apiContainer `json:"initContainers"`
+		Containers     []apiContainer `json:"containers"`
+	} `json:"spec"`
+	Status struct {
+		PodIP string `json:"podIP"`
+	} `json:"status"`
+}
+
+type apiContainer struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+}
+
+type apiPodList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []apiPod `json:"items"`
+}
+
+type apiWatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type apiStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// errExpired returned when watch version is too old and pods must be listed again
+var errExpired = fmt.Errorf("resource version expired")
+
+// PodsProvider watches pods of node through Kubernetes API and caches their metadata by pod uid
+type PodsProvider struct {
+	client   *http.Client
+	url      string
+	token    string
+	nodeName string
+	retry    time.Duration
+	timeout  time.Duration
+	mu       sync.RWMutex
+	pods     map[string]*Pod
+	names    map[string]string
+	version  string
+}
+
+// NewPodsProvider creates new PodsProvider for API server by url, token may be empty.
+// Client should not have timeout, watch is long request, listing is limited by its own timeout.
+func NewPodsProvider(apiURL string, token string, client *http.Client, nodeName string) *PodsProvider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &PodsProvider{
+		client:   client,
+		url:      strings.TrimSuffix(apiURL, "/"),
+		token:    token,
+		nodeName: nodeName,
+		retry:    5 * time.Second,
+		timeout:  listTimeout,
+		pods:     make(map[string]*Pod),
+		names:    make(map[string]string),
+	}
+}
+
+// NewInClusterPodsProvider creates new PodsProvider with API server address and credentials
+// of service account of pod, apiURL overrides API server address if not empty
+func NewInClusterPodsProvider(apiURL string, nodeName string) (*PodsProvider, error) {
+	if apiURL == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("unable to detect Kubernetes API address, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+		}
+		apiURL = "https://" + net.JoinHostPort(host, port)
+	}
+	token, err := ioutil.ReadFile(serviceAccountToken)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read service account token, %w", err)
+	}
+	ca, err := ioutil.ReadFile(serviceAccountCA)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read service account CA, %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("unable to parse service account CA %s", serviceAccountCA)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 5 * time.Second}).DialContext,
+		TLSClientConfig:       &tls.Config{RootCAs: pool},
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: listTimeout,
+	}}
+	return NewPodsProvider(apiURL, strings.TrimSpace(string(token)), client, nodeName), nil
+}
+
+// Find returns pod by uid, or by namespace and name if uid is empty or unknown, nil if pod is not found
+func (p *PodsProvider) Find(uid string, namespace string, name string) *Pod {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if pod, ok := p.pods[uid]; ok {
+		return pod
+	}
+	if uid, ok := p.names[namespace+"/"+name]; ok {
+		return p.pods[uid]
+	}
+	return nil
+}
+
+// Sync lists pods of node and replaces cache with them
+func (p *PodsProvider) Sync() error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	var list apiPodList
+	if err := p.get(ctx, p.podsURL(nil), &list); err != nil {
+		return err
+	}
+	pods := make(map[string]*Pod, len(list.Items))
+	names := make(map[string]string, len(list.Items))
+	for i := range list.Items {
+		pod := newPod(&list.Items[i])
+		pods[pod.UID] = pod
+		names[pod.Namespace+"/"+pod.Name] = pod.UID
+	}
+	p.mu.Lock()
+	p.pods, p.names, p.version = pods, names, list.Metadata.ResourceVersion
+	p.mu.Unlock()
+	return nil
+}
+
+// Run watches pods changes until stop channel closed, pods are listed again
+// if watch fails or its version expired
+func (p *PodsProvider) Run(stop <-chan bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	for {
+		p.mu.RLock()
+		version := p.version
+		p.mu.RUnlock()
+		var err error
+		if version == "" {
+			err = p.Sync()
+		} else {
+			err = p.watch(ctx, version)
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if err == nil {
+			continue
+		}
+		log.Printf("Error: unable to watch pods of node %s, %s", p.nodeName, err)
+		p.mu.Lock()
+		p.version = ""
+		p.mu.Unlock()
+		select {
+		case <-stop:
+			return
+		case <-time.After(p.retry):
+		}
+	}
+}
+
+// watch applies pods changes since version till API server closes watch
+func (p *PodsProvider) watch(ctx context.Context, version string) error {
+	query := url.Values{}
+	query.Set("watch", "true")
+	query.Set("resourceVersion", version)
+	query.Set("timeoutSeconds", fmt.Sprint(watchTimeoutSec))
+	// API server may not close watch if connection is broken silently
+	ctx, cancel := context.WithTimeout(ctx, watchTimeoutSec*time.Second+p.timeout)
+	defer cancel()
+	resp, err := p.do(ctx, p.podsURL(query))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event apiWatchEvent
+		if err := decoder.Decode(&event); err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				// Watch closed by API server after timeout, by its deadline or stopped
+				return nil
+			}
+			return fmt.Errorf("unable to decode watch event, %w", err)
+		}
+		if event.Type == "ERROR" {
+			var status apiStatus
+			if err := json.Unmarshal(event.Object, &status); err != nil {
+				return fmt.Errorf("unable to decode watch error, %w", err)
+			}
+			if status.Code == http.StatusGone {
+				return errExpired
+			}
+			return fmt.Errorf("watch error %d: %s", status.Code, status.Message)
+		}
+		var object apiPod
+		if err := json.Unmarshal(event.Object, &object); err != nil {
+			return fmt.Errorf("unable to decode pod, %w", err)
+		}
+		pod := newPod(&object)
+		p.mu.Lock()
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			p.pods[pod.UID] = pod
+			p.names[pod.Namespace+"/"+pod.Name] = pod.UID
+		case "DELETED":
+			delete(p.pods, pod.UID)
+			if p.names[pod.Namespace+"/"+pod.Name] == pod.UID {
+				delete(p.names, pod.Namespace+"/"+pod.Name)
+			}
+		}
+		if object.Metadata.ResourceVersion != "" {
+			p.version = object.Metadata.ResourceVersion
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (p *PodsProvider) podsURL(query url.Values) string {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("fieldSelector", "spec.nodeName="+p.nodeName)
+	return p.url + "/api/v1/pods?" + query.Encode()
+}
+
+func (p *PodsProvider) get(ctx context.Context, u string, out interface{}) error {
+	resp, err := p.do(ctx, u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("unable to decode response of %s, %w", u, err)
+	}
+	return nil
+}
+
+func (p *PodsProvider) do(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusGone {
+			return nil, errExpired
+		}
+		return nil, fmt.Errorf("unexpected status %d of %s: %s", resp.StatusCode, u, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+func newPod(o *apiPod) *Pod {
+	pod := &Pod{
+		UID:         o.Metadata.UID,
+		Namespace:   o.Metadata.Namespace,
+		Name:        o.Metadata.Name,
+		Labels:      o.Metadata.Labels,
+		Annotations: o.Metadata.Annotations,
+		PodIP:       o.Status.PodIP,
+		Images:      make(map[string]string),
+	}
+	for _, owner := range o.Metadata.OwnerReferences {
+		if owner.Controller || pod.OwnerKind == "" {
+			pod.OwnerKind, pod.OwnerName = owner.Kind, owner.Name
+		}
+		if owner.Controller {
+			break
+		}
+	}
+	for _, c := range append(o.Spec.InitContainers, o.Spec.Containers...) {
+		pod.Images[c.Name] = c.Image
+	}
+	return pod
+}
+
+// MatchKey checks that label or annotation key is selected by patterns, pattern is key
+// or prefix of keys ending with '*'
+func MatchKey(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if pattern == key || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(key, strings.TrimSuffix(pattern, "*"))) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package k8s
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPod = `{"metadata":{"uid":"%s","namespace":"default","name":"%s","resourceVersion":"%s",` +
+	`"labels":{"app":"web","app.kubernetes.io/version":"%s"},"annotations":{"team":"core"},` +
+	`"ownerReferences":[{"kind":"Node","name":"node-1"},{"kind":"ReplicaSet","name":"web-5d4f","controller":true}]},` +
+	`"spec":{"initContainers":[{"name":"init","image":"busybox"}],"containers":[{"name":"nginx","image":"nginx:1.19"}]},` +
+	`"status":{"podIP":"10.0.0.5"}}`
+
+type fakeAPIServer struct {
+	t      *testing.T
+	events chan string
+	stale  bool
+}
+
+func (s *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	assert.Equal(s.t, "/api/v1/pods", r.URL.Path)
+	assert.Equal(s.t, "spec.nodeName=node-1", r.URL.Query().Get("fieldSelector"))
+	assert.Equal(s.t, "Bearer secret", r.Header.Get("Authorization"))
+	if r.URL.Query().Get("watch") != "true" {
+		fmt.Fprintf(w, `{"metadata":{"resourceVersion":"10"},"items":[`+testPod+`]}`, "uid-1", "web-1", "9", "v1")
+		return
+	}
+	if s.stale {
+		s.stale = false
+		fmt.Fprint(w, `{"type":"ERROR","object":{"kind":"Status","code":410,"message":"too old resource version"}}`)
+		return
+	}
+	assert.Equal(s.t, "10", r.URL.Query().Get("resourceVersion"))
+	for {
+		select {
+		case event := <-s.events:
+			fmt.Fprintln(w, event)
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func TestPodsProvider(t *testing.T) {
+	api := &fakeAPIServer{t: t, events: make(chan string), stale: true}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	p := NewPodsProvider(server.URL, "secret", server.Client(), "node-1")
+	p.retry = 10 * time.Millisecond
+	assert.Nil(t, p.Find("uid-1", "", ""))
+	assert.NoError(t, p.Sync())
+
+	pod := p.Find("uid-1", "", "")
+	assert.Equal(t, &Pod{
+		UID:         "uid-1",
+		Namespace:   "default",
+		Name:        "web-1",
+		Labels:      map[string]string{"app": "web", "app.kubernetes.io/version": "v1"},
+		Annotations: map[string]string{"team": "core"},
+		OwnerKind:   "ReplicaSet",
+		OwnerName:   "web-5d4f",
+		PodIP:       "10.0.0.5",
+		Images:      map[string]string{"init": "busybox", "nginx": "nginx:1.19"},
+	}, pod)
+	assert.Equal(t, pod, p.Find("", "default", "web-1"))
+	assert.Nil(t, p.Find("uid-2", "default", "web-2"))
+
+	stop := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		p.Run(stop)
+		close(done)
+	}()
+
+	// The first watch fails with expired version, pods are listed again and watched from version 10
+	api.events <- fmt.Sprintf(`{"type":"ADDED","object":`+testPod+`}`, "uid-2", "web-2", "11", "v1")
+	assert.Eventually(t, func() bool { return p.Find("uid-2", "", "") != nil }, time.Second, 10*time.Millisecond)
+
+	api.events <- fmt.Sprintf(`{"type":"MODIFIED","object":`+testPod+`}`, "uid-2", "web-2", "12", "v2")
+	assert.Eventually(t, func() bool {
+		return p.Find("uid-2", "", "").Labels["app.kubernetes.io/version"] == "v2"
+	}, time.Second, 10*time.Millisecond)
+
+	api.events <- fmt.Sprintf(`{"type":"DELETED","object":`+testPod+`}`, "uid-1", "web-1", "13", "v1")
+	assert.Eventually(t, func() bool { return p.Find("uid-1", "default", "web-1") == nil }, time.Second, 10*time.Millisecond)
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("provider is not stopped")
+	}
+}
+
+func TestPodsProviderListTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	p := NewPodsProvider(server.URL, "", nil, "node-1")
+	p.timeout = 50 * time.Millisecond
+	started := time.Now()
+	assert.Error(t, p.Sync())
+	assert.True(t, time.Since(started) < time.Second)
+}
+
+func TestMatchKey(t *testing.T) {
+	assert.True(t, MatchKey([]string{"app"}, "app"))
+	assert.False(t, MatchKey([]string{"app"}, "app.kubernetes.io/name"))
+	assert.True(t, MatchKey([]string{"team", "app.kubernetes.io/*"}, "app.kubernetes.io/name"))
+	assert.True(t, MatchKey([]string{"*"}, "anything"))
+	assert.False(t, MatchKey(nil, "app"))
+}
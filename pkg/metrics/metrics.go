@@ -15,8 +15,72 @@ var LogMessageCount = prometheus.NewCounterVec(
 		Help: "Store all processed log messages per one container",
 	}, []string{"namespace", "pod_name", "container_name"})
 
+// QueueDepth store number of batches waiting for delivery in disk queue
+var QueueDepth = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "queue_depth_batches",
+		Help: "Store number of batches waiting for delivery in disk queue",
+	})
+
+// QueueSize store size of batches waiting for delivery in disk queue
+var QueueSize = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "queue_size_bytes",
+		Help: "Store size of batches waiting for delivery in disk queue",
+	})
+
+// QueueOldestBatchAge store age of the oldest batch in disk queue
+var QueueOldestBatchAge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "queue_oldest_batch_age_seconds",
+		Help: "Store age of the oldest batch waiting for delivery in disk queue",
+	})
+
+// BreakerState store circuit breaker state per transport: 0 - closed, 1 - open, 2 - half-open
+var BreakerState = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "breaker_state",
+		Help: "Store circuit breaker state per transport: 0 - closed, 1 - open, 2 - half-open",
+	}, []string{"transport"})
+
+// TransportHealthy store result of the last transport health check: 1 - healthy, 0 - not healthy
+var TransportHealthy = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "transport_healthy",
+		Help: "Store result of the last transport health check: 1 - healthy, 0 - not healthy",
+	})
+
+// RedactionsCount store number of values redacted by redact processor per detector
+var RedactionsCount = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "redactions_count",
+		Help: "Store number of values redacted by redact processor per detector",
+	}, []string{"detector", "action"})
+
+// RateLimitedCount store number of records dropped, sampled out or delayed by rate limit per one container
+var RateLimitedCount = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "rate_limited_count",
+		Help: "Store number of records dropped, sampled out or delayed by rate limit per one container",
+	}, []string{"namespace", "pod_name", "container_name", "action"})
+
+// SampledOutCount store number of records dropped by sampling per one container
+var SampledOutCount = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "sampled_out_count",
+		Help: "Store number of records dropped by sampling per one container",
+	}, []string{"namespace", "pod_name", "container_name"})
+
 func init() {
 	prometheus.MustRegister(LogMessageCount)
+	prometheus.MustRegister(QueueDepth)
+	prometheus.MustRegister(QueueSize)
+	prometheus.MustRegister(QueueOldestBatchAge)
+	prometheus.MustRegister(BreakerState)
+	prometheus.MustRegister(TransportHealthy)
+	prometheus.MustRegister(RedactionsCount)
+	prometheus.MustRegister(RateLimitedCount)
+	prometheus.MustRegister(SampledOutCount)
 }
 
 // ServeHTTPRequests start http service for handle metrics
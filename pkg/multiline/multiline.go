@@ -0,0 +1,152 @@
+package multiline
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"rvadim/loggo/pkg/parser"
+)
+
+// Rule modes
+const (
+	// ModeStart line matched by pattern starts new event, other lines continue it
+	ModeStart = iota
+	// ModeContinue line matched by pattern continues current event, other lines start new one
+	ModeContinue
+)
+
+// BodyKey name of record field which store log message
+const BodyKey = "log"
+
+// Rule describes how to join lines into events for containers matched by Container regex
+type Rule struct {
+	Container *regexp.Regexp
+	Mode      int
+	Pattern   *regexp.Regexp
+}
+
+// ParseRule parses rule from string '<container-regex>:<start|continue>:<pattern>'
+func ParseRule(rule string) (*Rule, error) {
+	parts := strings.SplitN(rule, ":", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid multiline rule '%s', expected '<container-regex>:<start|continue>:<pattern>'", rule)
+	}
+	r := &Rule{}
+	switch parts[1] {
+	case "start":
+		r.Mode = ModeStart
+	case "continue":
+		r.Mode = ModeContinue
+	default:
+		return nil, fmt.Errorf("invalid multiline rule '%s', unknown mode '%s'", rule, parts[1])
+	}
+	var err error
+	r.Container, err = regexp.Compile(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid multiline rule '%s', %w", rule, err)
+	}
+	r.Pattern, err = regexp.Compile(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid multiline rule '%s', %w", rule, err)
+	}
+	return r, nil
+}
+
+// FindRule returns the first rule matched by container name or nil
+func FindRule(rules []*Rule, container string) *Rule {
+	for _, r := range rules {
+		if r.Container.MatchString(container) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Joiner joins records of one event (for example stack trace) into single record.
+// Bodies of joined records are concatenated, other fields are taken from the first record.
+// Joiner is a parser.Processor, it should be the first one in processors chain.
+type Joiner struct {
+	rule     *Rule
+	maxLines int
+	maxBytes int
+	timeout  time.Duration
+	pending  parser.Properties
+	body     strings.Builder
+	lines    int
+	updated  time.Time
+}
+
+// NewJoiner creates new Joiner, event emitted when it reaches maxLines or maxBytes
+// (zero means unlimited) or when no new lines arrived during timeout
+func NewJoiner(rule *Rule, maxLines int, maxBytes int, timeout time.Duration) *Joiner {
+	return &Joiner{
+		rule:     rule,
+		maxLines: maxLines,
+		maxBytes: maxBytes,
+		timeout:  timeout,
+	}
+}
+
+// Process adds record to the current event. Returns previous event when record
+// does not belong to it or nil if there is nothing to emit yet.
+func (j *Joiner) Process(record parser.Properties) (parser.Properties, error) {
+	body, _ := record[BodyKey].(string)
+	var output parser.Properties
+	if j.pending != nil && (j.isNewEvent(body) || j.isFull(body)) {
+		output = j.emit()
+	}
+	if j.pending == nil {
+		j.pending = record
+	} else if !strings.HasSuffix(j.body.String(), "\n") {
+		j.body.WriteString("\n")
+	}
+	j.body.WriteString(body)
+	j.lines++
+	j.updated = time.Now()
+	return output, nil
+}
+
+// Held returns pending event
+func (j *Joiner) Held() []parser.Properties {
+	if j.pending == nil {
+		return nil
+	}
+	return []parser.Properties{j.pending}
+}
+
+// Flush returns pending event if no lines added during timeout or force is true
+func (j *Joiner) Flush(force bool) parser.Properties {
+	if j.pending == nil {
+		return nil
+	}
+	if !force && time.Since(j.updated) < j.timeout {
+		return nil
+	}
+	return j.emit()
+}
+
+func (j *Joiner) isNewEvent(body string) bool {
+	matched := j.rule.Pattern.MatchString(strings.TrimRight(body, "\r\n"))
+	if j.rule.Mode == ModeStart {
+		return matched
+	}
+	return !matched
+}
+
+func (j *Joiner) isFull(body string) bool {
+	if j.maxLines > 0 && j.lines >= j.maxLines {
+		return true
+	}
+	return j.maxBytes > 0 && j.body.Len()+len(body) > j.maxBytes
+}
+
+func (j *Joiner) emit() parser.Properties {
+	output := j.pending
+	output[BodyKey] = j.body.String()
+	j.pending = nil
+	j.body.Reset()
+	j.lines = 0
+	return output
+}
@@ -0,0 +1,86 @@
+package multiline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"rvadim/loggo/pkg/parser"
+)
+
+func record(body string) parser.Properties {
+	return parser.Properties{"log": body, "stream": "stdout"}
+}
+
+func add(j *Joiner, body string) parser.Properties {
+	out, _ := j.Process(record(body))
+	return out
+}
+
+func TestParseRule(t *testing.T) {
+	r, err := ParseRule(`^java-.*:start:^\d{4}-\d{2}-\d{2}`)
+	assert.NoError(t, err)
+	assert.Equal(t, ModeStart, r.Mode)
+	assert.True(t, r.Container.MatchString("java-app"))
+	assert.True(t, r.Pattern.MatchString("2020-01-01 ERROR"))
+
+	r, err = ParseRule(`.*:continue:^\s`)
+	assert.NoError(t, err)
+	assert.Equal(t, ModeContinue, r.Mode)
+
+	_, err = ParseRule(`.*:unknown:^\s`)
+	assert.Error(t, err)
+	_, err = ParseRule(`.*`)
+	assert.Error(t, err)
+	_, err = ParseRule(`.*:start:(`)
+	assert.Error(t, err)
+
+	java, _ := ParseRule(`^java-.*:start:^\d{4}-`)
+	rules := []*Rule{java, r}
+	assert.Equal(t, java, FindRule(rules, "java-app"))
+	assert.Equal(t, r, FindRule(rules, "python-app"))
+	assert.Nil(t, FindRule(nil, "python-app"))
+}
+
+func TestJoinerStartPattern(t *testing.T) {
+	r, _ := ParseRule(`.*:start:^\d{4}-`)
+	j := NewJoiner(r, 0, 0, time.Hour)
+	assert.Nil(t, add(j, "2020-01-01 Exception in thread \"main\"\n"))
+	assert.Nil(t, add(j, "\tat com.example.Main.main(Main.java:5)\n"))
+	out := add(j, "2020-01-01 next event\n")
+	assert.Equal(t, "2020-01-01 Exception in thread \"main\"\n\tat com.example.Main.main(Main.java:5)\n", out["log"])
+	assert.Equal(t, "stdout", out["stream"])
+
+	assert.Nil(t, j.Flush(false))
+	out = j.Flush(true)
+	assert.Equal(t, "2020-01-01 next event\n", out["log"])
+	assert.Nil(t, j.Flush(true))
+}
+
+func TestJoinerContinuePattern(t *testing.T) {
+	r, _ := ParseRule(`.*:continue:^(\s|Traceback|\w+Error)`)
+	j := NewJoiner(r, 0, 0, 10*time.Millisecond)
+	assert.Nil(t, add(j, "Traceback (most recent call last):"))
+	assert.Nil(t, add(j, "  File \"main.py\", line 1, in <module>"))
+	assert.Nil(t, add(j, "ZeroDivisionError: division by zero"))
+	out := add(j, "next line")
+	assert.Equal(t, "Traceback (most recent call last):\n  File \"main.py\", line 1, in <module>\nZeroDivisionError: division by zero", out["log"])
+
+	time.Sleep(20 * time.Millisecond)
+	out = j.Flush(false)
+	assert.Equal(t, "next line", out["log"])
+}
+
+func TestJoinerLimits(t *testing.T) {
+	r, _ := ParseRule(`.*:continue:^\s`)
+	j := NewJoiner(r, 2, 0, time.Hour)
+	assert.Nil(t, add(j, "a\n"))
+	assert.Nil(t, add(j, " b\n"))
+	assert.Equal(t, "a\n b\n", add(j, " c\n")["log"])
+	assert.Equal(t, " c\n", j.Flush(true)["log"])
+
+	j = NewJoiner(r, 0, 5, time.Hour)
+	assert.Nil(t, add(j, "a\n"))
+	assert.Nil(t, add(j, " b\n"))
+	assert.Equal(t, "a\n b\n", add(j, " c\n")["log"])
+}
@@ -0,0 +1,147 @@
+package parser
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Coercion types
+const (
+	CoerceInt   = "int"
+	CoerceFloat = "float"
+	CoerceBool  = "bool"
+	// CoerceDuration converts Go duration ('1.5s', '250ms') or number of seconds to float seconds
+	CoerceDuration = "duration"
+	// CoerceLast converts the last value of comma separated list to float, as nginx
+	// writes upstream_response_time of every upstream tried
+	CoerceLast = "last"
+)
+
+// DefaultCoerceRule keeps upstream_response_time handling of previous versions
+const DefaultCoerceRule = "upstream_response_time=last:_float"
+
+// CoerceRule converts fields matched by Field glob to Type. Converted value is written
+// to field with Suffix added to its name, or replaces value if Suffix is empty.
+type CoerceRule struct {
+	Field  string
+	Type   string
+	Suffix string
+}
+
+// ParseCoerceRule parses rule from string '<field-glob>=<type>[:<suffix>]'
+func ParseCoerceRule(rule string) (CoerceRule, error) {
+	field, err := ParseField(rule)
+	if err != nil {
+		return CoerceRule{}, fmt.Errorf("invalid coerce rule '%s', expected '<field-glob>=<type>[:<suffix>]'", rule)
+	}
+	parts := strings.SplitN(field.Value, ":", 2)
+	r := CoerceRule{Field: field.Key, Type: parts[0]}
+	if len(parts) == 2 {
+		r.Suffix = parts[1]
+	}
+	if _, err = path.Match(r.Field, ""); err != nil {
+		return CoerceRule{}, fmt.Errorf("invalid coerce rule '%s', %w", rule, err)
+	}
+	switch r.Type {
+	case CoerceInt, CoerceFloat, CoerceBool, CoerceDuration, CoerceLast:
+	default:
+		return CoerceRule{}, fmt.Errorf("invalid coerce rule '%s', unknown type '%s'", rule, r.Type)
+	}
+	return r, nil
+}
+
+// CoerceProcessor converts field values by rules, values which can not be converted are kept as is
+type CoerceProcessor struct {
+	rules []CoerceRule
+}
+
+// NewCoerceProcessor creates new CoerceProcessor
+func NewCoerceProcessor(rules []CoerceRule) *CoerceProcessor {
+	return &CoerceProcessor{rules: rules}
+}
+
+// Process applies rules in configured order
+func (p *CoerceProcessor) Process(record Properties) (Properties, error) {
+	for _, r := range p.rules {
+		for _, key := range matchFields(record, r.Field) {
+			value, ok := coerce(record[key], r.Type)
+			if !ok {
+				continue
+			}
+			record[key+r.Suffix] = value
+		}
+	}
+	return record, nil
+}
+
+// matchFields returns sorted names of record fields matched by glob
+func matchFields(record Properties, glob string) []string {
+	if !strings.ContainsAny(glob, `*?[\`) {
+		if _, ok := record[glob]; ok {
+			return []string{glob}
+		}
+		return nil
+	}
+	var keys []string
+	for key := range record {
+		if ok, _ := path.Match(glob, key); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// coerce converts value to type, false returned if value can not be converted
+func coerce(value interface{}, typ string) (interface{}, bool) {
+	switch v := value.(type) {
+	case float64:
+		switch typ {
+		case CoerceInt:
+			return int64(v), v == float64(int64(v))
+		case CoerceFloat, CoerceDuration, CoerceLast:
+			return v, true
+		}
+	case int64:
+		switch typ {
+		case CoerceInt:
+			return v, true
+		case CoerceFloat, CoerceDuration, CoerceLast:
+			return float64(v), true
+		}
+	case bool:
+		return v, typ == CoerceBool
+	case string:
+		return coerceString(strings.TrimSpace(v), typ)
+	}
+	return nil, false
+}
+
+func coerceString(value string, typ string) (interface{}, bool) {
+	var out interface{}
+	var err error
+	switch typ {
+	case CoerceInt:
+		out, err = strconv.ParseInt(value, 10, 64)
+	case CoerceFloat:
+		out, err = strconv.ParseFloat(value, 64)
+	case CoerceBool:
+		out, err = strconv.ParseBool(value)
+	case CoerceDuration:
+		if out, err = strconv.ParseFloat(value, 64); err != nil {
+			var d time.Duration
+			d, err = time.ParseDuration(value)
+			out = d.Seconds()
+		}
+	case CoerceLast:
+		values := strings.Split(value, ",")
+		out, err = strconv.ParseFloat(strings.TrimSpace(values[len(values)-1]), 64)
+	default:
+		return nil, false
+	}
+	return out, err == nil
+}
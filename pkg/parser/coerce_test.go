@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCoerceRule(t *testing.T) {
+	r, err := ParseCoerceRule(DefaultCoerceRule)
+	assert.NoError(t, err)
+	assert.Equal(t, CoerceRule{Field: "upstream_response_time", Type: CoerceLast, Suffix: "_float"}, r)
+	r, err = ParseCoerceRule("*_bytes=int")
+	assert.NoError(t, err)
+	assert.Equal(t, CoerceRule{Field: "*_bytes", Type: CoerceInt}, r)
+
+	_, err = ParseCoerceRule("status")
+	assert.Error(t, err)
+	_, err = ParseCoerceRule("status=string")
+	assert.Error(t, err)
+	_, err = ParseCoerceRule("[=int")
+	assert.Error(t, err)
+}
+
+func TestCoerceProcessor(t *testing.T) {
+	p := NewCoerceProcessor([]CoerceRule{
+		{Field: "status", Type: CoerceInt},
+		{Field: "*_bytes", Type: CoerceInt},
+		{Field: "request_time", Type: CoerceFloat, Suffix: "_float"},
+		{Field: "cached", Type: CoerceBool},
+		{Field: "latency", Type: CoerceDuration},
+		{Field: "timeout", Type: CoerceDuration},
+		{Field: "upstream_response_time", Type: CoerceLast, Suffix: "_float"},
+		{Field: "missing", Type: CoerceInt},
+	})
+	record, err := p.Process(Properties{
+		"status":                 "200",
+		"body_bytes":             "1024",
+		"sent_bytes":             12.5,
+		"request_time":           "0.250",
+		"cached":                 "true",
+		"latency":                "1.5s",
+		"timeout":                "30",
+		"upstream_response_time": "0.1, -",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, Properties{
+		"status":                 int64(200),
+		"body_bytes":             int64(1024),
+		"sent_bytes":             12.5,
+		"request_time":           "0.250",
+		"request_time_float":     0.25,
+		"cached":                 true,
+		"latency":                1.5,
+		"timeout":                30.0,
+		"upstream_response_time": "0.1, -",
+	}, record)
+}
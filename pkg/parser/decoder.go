@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Decoder turns runtime specific log line into record with log, stream and time fields.
+// Decoder may buffer lines (for example partial entries), in that case it returns nil record.
+type Decoder interface {
+	Decode(line string) (Properties, error)
+	Flush(force bool) []Properties
+}
+
+// TruncatedDecoder implemented by decoders which can decode the beginning of line, when line
+// is too long to be read whole. Such record is not joined with partial entries.
+type TruncatedDecoder interface {
+	DecodeTruncated(line string) (Properties, error)
+}
+
+// DockerDecoder decodes docker json-file log lines
+type DockerDecoder struct {
+	offset   int64
+	partials *Partials
+}
+
+// NewDockerDecoder creates new DockerDecoder. partialMaxBytes enables reassembling of
+// lines which docker splits into several entries (longer than 16KiB) and limits size of
+// reassembled line, longer line is truncated, 0 disables reassembling. Line is sent without
+// its last part if the part did not arrive during partialTimeout (for example container is killed).
+func NewDockerDecoder(partialMaxBytes int, partialTimeout time.Duration) *DockerDecoder {
+	d := &DockerDecoder{}
+	if partialMaxBytes > 0 {
+		d.partials = NewPartials(partialMaxBytes, partialTimeout)
+	}
+	return d
+}
+
+// Decode parses JSON entry of docker log file
+func (d *DockerDecoder) Decode(line string) (Properties, error) {
+	var i Properties
+	err := json.Unmarshal([]byte(line), &i)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse input %s, due to err %s", line, err)
+	}
+	logLine, ok := i["log"].(string)
+	if !ok {
+		return nil, fmt.Errorf("Unable to parse input %s, no log field", line)
+	}
+	i[OffsetKey] = d.offset
+	if d.partials != nil {
+		// Partial entry (log does not end with newline) is followed by the rest of line
+		return d.partials.Join(i, !strings.HasSuffix(logLine, "\n")), nil
+	}
+	return i, nil
+}
+
+// SetDropOversized makes reassembled lines longer than limit dropped instead of truncated
+func (d *DockerDecoder) SetDropOversized(drop bool) {
+	if d.partials != nil {
+		d.partials.SetDropOversized(drop)
+	}
+}
+
+// SetOffset sets offset of the next decoded line
+func (d *DockerDecoder) SetOffset(offset int64) {
+	d.offset = offset
+}
+
+// Held returns buffered partial entries
+func (d *DockerDecoder) Held() []Properties {
+	if d.partials == nil {
+		return nil
+	}
+	return d.partials.Held()
+}
+
+// Flush returns buffered partial entries if their last part did not arrive in time or force is true
+func (d *DockerDecoder) Flush(force bool) []Properties {
+	if d.partials == nil {
+		return nil
+	}
+	return d.partials.Flush(force)
+}
+
+// DecodeTruncated decodes log field from the beginning of docker entry, docker writes log field
+// first, so other fields are lost
+func (d *DockerDecoder) DecodeTruncated(line string) (Properties, error) {
+	const prefix = `{"log":"`
+	if !strings.HasPrefix(line, prefix) {
+		return nil, fmt.Errorf("Unable to parse truncated input, no log field at the beginning")
+	}
+	body := line[len(prefix):]
+	end := 0
+	for end < len(body) && body[end] != '"' {
+		if body[end] == '\\' {
+			n := 2
+			if end+1 < len(body) && body[end+1] == 'u' {
+				n = 6
+			}
+			if end+n > len(body) {
+				// Escape sequence is cut, drop it
+				break
+			}
+			end += n
+			continue
+		}
+		end++
+	}
+	var logLine string
+	if err := json.Unmarshal([]byte(`"`+body[:end]+`"`), &logLine); err != nil {
+		return nil, fmt.Errorf("Unable to parse truncated input, due to err %s", err)
+	}
+	return Properties{"log": logLine}, nil
+}
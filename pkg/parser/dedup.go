@@ -0,0 +1,146 @@
+package parser
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// RepeatCountKey name of field with number of collapsed identical records
+const RepeatCountKey = "repeat_count"
+
+// Dedup keys
+const (
+	// DedupKeyMessage records are identical if their messages are equal
+	DedupKeyMessage = "message"
+	// DedupKeyMessageLevel records are identical if their messages and levels are equal
+	DedupKeyMessageLevel = "message+level"
+)
+
+// dedupMessageFields are fields with message, the first present one is used
+var dedupMessageFields = []string{"log", "message", "msg"}
+
+// DedupRule enables dedup for containers selected by Scope
+type DedupRule struct {
+	Scope
+}
+
+// ParseDedupRule parses rule from string '<namespace-regex>:<container-regex>'
+func ParseDedupRule(rule string) (*DedupRule, error) {
+	parts := strings.SplitN(rule, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid dedup rule '%s', expected '<namespace-regex>:<container-regex>'", rule)
+	}
+	scope, err := NewScope(parts[0], parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid dedup rule '%s', %w", rule, err)
+	}
+	return &DedupRule{Scope: scope}, nil
+}
+
+// FindDedupRule returns the first rule matched by namespace and container name or nil
+func FindDedupRule(rules []*DedupRule, namespace string, container string) *DedupRule {
+	for _, r := range rules {
+		if r.Match(namespace, container) {
+			return r
+		}
+	}
+	return nil
+}
+
+// DedupOptions configure dedup processor
+type DedupOptions struct {
+	// Key is message (default) or message+level
+	Key string
+	// Window limits time during which identical records are collapsed into one
+	Window time.Duration
+	// MaxBytes limits message size, longer messages are not collapsed, 0 means unlimited
+	MaxBytes int
+}
+
+// DedupProcessor collapses identical consecutive records into one record with RepeatCountKey.
+// Only the first record of series is kept in memory, following ones are compared by key hash.
+type DedupProcessor struct {
+	o       DedupOptions
+	held    Properties
+	hash    uint64
+	unique  bool
+	count   int
+	started time.Time
+	now     func() time.Time
+}
+
+// NewDedupProcessor creates new DedupProcessor
+func NewDedupProcessor(o DedupOptions) (*DedupProcessor, error) {
+	switch o.Key {
+	case "":
+		o.Key = DedupKeyMessage
+	case DedupKeyMessage, DedupKeyMessageLevel:
+	default:
+		return nil, fmt.Errorf("unknown dedup key '%s'", o.Key)
+	}
+	return &DedupProcessor{o: o, now: time.Now}, nil
+}
+
+// Process holds record till the next different one, returns previously held record
+// or nil if record is collapsed or held. Record without message (or with too long one)
+// is held too, but nothing is collapsed into it.
+func (p *DedupProcessor) Process(record Properties) (Properties, error) {
+	hash, ok := p.key(record)
+	if ok && p.held != nil && !p.unique && p.hash == hash && p.now().Sub(p.started) < p.o.Window {
+		p.count++
+		return nil, nil
+	}
+	previous := p.release()
+	p.held, p.hash, p.unique, p.count, p.started = record, hash, !ok, 1, p.now()
+	return previous, nil
+}
+
+// Held returns held record
+func (p *DedupProcessor) Held() []Properties {
+	if p.held == nil {
+		return nil
+	}
+	return []Properties{p.held}
+}
+
+// Flush returns held record when window passed or force is true
+func (p *DedupProcessor) Flush(force bool) Properties {
+	if p.held == nil || (!force && p.now().Sub(p.started) < p.o.Window) {
+		return nil
+	}
+	return p.release()
+}
+
+// release returns held record with repeat count and forgets it
+func (p *DedupProcessor) release() Properties {
+	record := p.held
+	if record != nil && p.count > 1 {
+		record[RepeatCountKey] = p.count
+	}
+	p.held = nil
+	return record
+}
+
+// key returns hash of record key, false if record has no message or message is too long
+func (p *DedupProcessor) key(record Properties) (uint64, bool) {
+	var message string
+	found := false
+	for _, field := range dedupMessageFields {
+		if value, ok := record[field]; ok {
+			message, found = fmt.Sprint(value), true
+			break
+		}
+	}
+	if !found || (p.o.MaxBytes > 0 && len(message) > p.o.MaxBytes) {
+		return 0, false
+	}
+	h := fnv.New64a()
+	h.Write([]byte(message))
+	if p.o.Key == DedupKeyMessageLevel {
+		h.Write([]byte{0})
+		h.Write([]byte(fmt.Sprint(record[LevelKey], record["stream"])))
+	}
+	return h.Sum64(), true
+}
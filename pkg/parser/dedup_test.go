@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDedupRule(t *testing.T) {
+	r, err := ParseDedupRule("^dev$:")
+	assert.NoError(t, err)
+	assert.Equal(t, r, FindDedupRule([]*DedupRule{r}, "dev", "app"))
+	assert.Nil(t, FindDedupRule([]*DedupRule{r}, "prod", "app"))
+	_, err = ParseDedupRule("dev")
+	assert.Error(t, err)
+	_, err = ParseDedupRule("(:")
+	assert.Error(t, err)
+	_, err = NewDedupProcessor(DedupOptions{Key: "level"})
+	assert.Error(t, err)
+}
+
+func TestDedupProcessor(t *testing.T) {
+	d, _ := NewDedupProcessor(DedupOptions{Window: 10 * time.Second, MaxBytes: 20})
+	now := time.Unix(0, 0)
+	d.now = func() time.Time { return now }
+	p := NewPipeline(NewDockerDecoder(0, 0), []Processor{d}, Properties{})
+
+	var output []string
+	for _, line := range []string{
+		`{"log":"crash\n","stream":"stderr"}`,
+		`{"log":"crash\n","stream":"stdout"}`,
+		`{"log":"crash\n","stream":"stderr"}`,
+		`{"log":"restart\n","stream":"stdout"}`,
+		`{"log":"a very long message which is not deduplicated\n"}`,
+		`{"log":"a very long message which is not deduplicated\n"}`,
+		`{"log":"crash\n"}`,
+	} {
+		out, err := p.ParseLine(line)
+		assert.NoError(t, err)
+		if out != "" {
+			output = append(output, out)
+		}
+	}
+	assert.Equal(t, []string{
+		`{"log":"crash\n","repeat_count":3,"stream":"stderr"}`,
+		`{"log":"restart\n","stream":"stdout"}`,
+		`{"log":"a very long message which is not deduplicated\n"}`,
+		`{"log":"a very long message which is not deduplicated\n"}`,
+	}, output)
+
+	assert.Nil(t, p.Flush(false))
+	out, _ := p.ParseLine(`{"log":"crash\n"}`)
+	assert.Equal(t, "", out)
+	now = now.Add(11 * time.Second)
+	assert.Equal(t, []string{`{"log":"crash\n","repeat_count":2}`}, p.Flush(false))
+	out, _ = p.ParseLine(`{"log":"crash\n"}`)
+	assert.Equal(t, "", out)
+	now = now.Add(11 * time.Second)
+	out, _ = p.ParseLine(`{"log":"crash\n"}`)
+	assert.Equal(t, `{"log":"crash\n"}`, out)
+	assert.Equal(t, []string{`{"log":"crash\n"}`}, p.Flush(true))
+	assert.Nil(t, p.Flush(true))
+
+	d, _ = NewDedupProcessor(DedupOptions{Key: DedupKeyMessageLevel, Window: time.Minute})
+	p = NewPipeline(NewDockerDecoder(0, 0), []Processor{d}, Properties{})
+	p.ParseLine(`{"log":"crash\n","stream":"stderr"}`)
+	out, _ = p.ParseLine(`{"log":"crash\n","stream":"stdout"}`)
+	assert.Equal(t, `{"log":"crash\n","stream":"stderr"}`, out)
+}
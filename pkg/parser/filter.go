@@ -0,0 +1,114 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Filter actions
+const (
+	// FilterDrop drops records matched by rule
+	FilterDrop = "drop"
+	// FilterKeep keeps only records matched by rule (or by any other keep rule)
+	FilterKeep = "keep"
+)
+
+// FilterRule drops or keeps records of containers selected by Scope. Record matches
+// rule if value of Field matches Pattern, empty Field means raw log message before it is parsed.
+type FilterRule struct {
+	Scope
+	Action  string
+	Field   string
+	Pattern *regexp.Regexp
+}
+
+// ParseFilterRule parses rule from string '<namespace-regex>:<container-regex>:<drop|keep>:[<field>]=<pattern>'
+func ParseFilterRule(rule string) (*FilterRule, error) {
+	parts := strings.SplitN(rule, ":", 4)
+	if len(parts) != 4 || !strings.Contains(parts[3], "=") {
+		return nil, fmt.Errorf("invalid filter rule '%s', expected '<namespace-regex>:<container-regex>:<drop|keep>:[<field>]=<pattern>'", rule)
+	}
+	r := &FilterRule{Action: parts[2]}
+	if r.Action != FilterDrop && r.Action != FilterKeep {
+		return nil, fmt.Errorf("invalid filter rule '%s', unknown action '%s'", rule, r.Action)
+	}
+	match := strings.SplitN(parts[3], "=", 2)
+	r.Field = match[0]
+	var err error
+	if r.Scope, err = NewScope(parts[0], parts[1]); err != nil {
+		return nil, fmt.Errorf("invalid filter rule '%s', %w", rule, err)
+	}
+	if r.Pattern, err = regexp.Compile(match[1]); err != nil {
+		return nil, fmt.Errorf("invalid filter rule '%s', %w", rule, err)
+	}
+	return r, nil
+}
+
+// FindFilterRules returns rules matched by namespace and container name, split into
+// rules on raw message and rules on parsed fields
+func FindFilterRules(rules []*FilterRule, namespace string, container string) (raw []*FilterRule, parsed []*FilterRule) {
+	for _, r := range rules {
+		if !r.Match(namespace, container) {
+			continue
+		}
+		if r.Field == "" {
+			raw = append(raw, r)
+		} else {
+			parsed = append(parsed, r)
+		}
+	}
+	return raw, parsed
+}
+
+// FilterProcessor drops records matched by any drop rule, and, if there are keep rules,
+// records not matched by any of them. Dropped records are not sent, but their lines
+// are read, so registry position advances.
+type FilterProcessor struct {
+	rules []*FilterRule
+	keep  bool
+}
+
+// NewFilterProcessor creates new FilterProcessor
+func NewFilterProcessor(rules []*FilterRule) *FilterProcessor {
+	p := &FilterProcessor{rules: rules}
+	for _, r := range rules {
+		if r.Action == FilterKeep {
+			p.keep = true
+		}
+	}
+	return p
+}
+
+// Process returns nil record if it is filtered out
+func (p *FilterProcessor) Process(record Properties) (Properties, error) {
+	kept := false
+	for _, r := range p.rules {
+		if !r.match(record) {
+			continue
+		}
+		if r.Action == FilterDrop {
+			return nil, nil
+		}
+		kept = true
+	}
+	if p.keep && !kept {
+		return nil, nil
+	}
+	return record, nil
+}
+
+func (r *FilterRule) match(record Properties) bool {
+	field := r.Field
+	if field == "" {
+		field = "log"
+	}
+	value, ok := record[field]
+	if !ok || value == nil {
+		return false
+	}
+	if s, ok := value.(string); ok {
+		return r.Pattern.MatchString(s)
+	}
+	return r.Pattern.MatchString(fmt.Sprint(value))
+}
@@ -0,0 +1,53 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFilterRule(t *testing.T) {
+	r, err := ParseFilterRule(`^noisy$::drop:level=^debug$`)
+	assert.NoError(t, err)
+	assert.Equal(t, FilterDrop, r.Action)
+	assert.Equal(t, "level", r.Field)
+
+	_, err = ParseFilterRule(`::skip:level=debug`)
+	assert.Error(t, err)
+	_, err = ParseFilterRule(`::drop:debug`)
+	assert.Error(t, err)
+	_, err = ParseFilterRule(`::drop:=(`)
+	assert.Error(t, err)
+	_, err = ParseFilterRule(`(::drop:=a`)
+	assert.Error(t, err)
+}
+
+func TestFindFilterRules(t *testing.T) {
+	r1, _ := ParseFilterRule(`^noisy$::drop:level=^debug$`)
+	r2, _ := ParseFilterRule(`:^nginx$:drop:=GET /healthz`)
+	r3, _ := ParseFilterRule(`^prod$:^nginx$:keep:status=^5`)
+	raw, parsed := FindFilterRules([]*FilterRule{r1, r2, r3}, "noisy", "nginx")
+	assert.Equal(t, []*FilterRule{r2}, raw)
+	assert.Equal(t, []*FilterRule{r1}, parsed)
+	raw, parsed = FindFilterRules([]*FilterRule{r1, r2, r3}, "prod", "api")
+	assert.Nil(t, raw)
+	assert.Nil(t, parsed)
+}
+
+func TestFilterProcessor(t *testing.T) {
+	drop, _ := ParseFilterRule(`::drop:=GET /healthz`)
+	keep, _ := ParseFilterRule(`::keep:status=^5`)
+	keepSlow, _ := ParseFilterRule(`::keep:slow=true`)
+
+	p := NewPipeline(NewDockerDecoder(0, 0), []Processor{NewFilterProcessor([]*FilterRule{drop}), &JSONProcessor{},
+		NewFilterProcessor([]*FilterRule{keep, keepSlow})}, Properties{})
+	out, err := p.ParseLine(`{"log":"{\"request\":\"GET /healthz\",\"status\":500}"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "", out)
+	out, _ = p.ParseLine(`{"log":"{\"request\":\"GET /\",\"status\":200}"}`)
+	assert.Equal(t, "", out)
+	out, _ = p.ParseLine(`{"log":"{\"request\":\"GET /\",\"status\":503}"}`)
+	assert.Equal(t, `{"request":"GET /","status":503}`, out)
+	out, _ = p.ParseLine(`{"log":"{\"request\":\"GET /\",\"status\":200,\"slow\":true}"}`)
+	assert.Equal(t, `{"request":"GET /","slow":true,"status":200}`, out)
+}
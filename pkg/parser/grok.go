@@ -0,0 +1,245 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TagParseFailure added to tags of record which log field did not match any grok pattern
+const TagParseFailure = "_parse_failure"
+
+// TagsKey name of record field which store list of tags
+const TagsKey = "tags"
+
+// GrokPatterns is a built-in library of grok patterns, patterns may refer to each other
+var GrokPatterns = map[string]string{
+	"WORD":              `\b\w+\b`,
+	"NOTSPACE":          `\S+`,
+	"SPACE":             `\s*`,
+	"DATA":              `.*?`,
+	"GREEDYDATA":        `.*`,
+	"INT":               `[+-]?\d+`,
+	"POSINT":            `\b[1-9]\d*\b`,
+	"NONNEGINT":         `\b\d+\b`,
+	"BASE10NUM":         `[+-]?(?:\d+(?:\.\d*)?|\.\d+)`,
+	"NUMBER":            `%{BASE10NUM}`,
+	"BASE16NUM":         `[+-]?(?:0x)?[0-9A-Fa-f]+`,
+	"UUID":              `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"USERNAME":          `[a-zA-Z0-9._-]+`,
+	"USER":              `%{USERNAME}`,
+	"IPV4":              `(?:\d{1,3}\.){3}\d{1,3}`,
+	"IPV6":              `(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}(?:%\w+)?`,
+	"IP":                `(?:%{IPV6}|%{IPV4})`,
+	"HOSTNAME":          `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?\b`,
+	"IPORHOST":          `(?:%{IP}|%{HOSTNAME})`,
+	"HOSTPORT":          `%{IPORHOST}:%{POSINT}`,
+	"PATH":              `(?:/[^\s?#]*)+`,
+	"URIPARAM":          `\?\S*`,
+	"URIPATHPARAM":      `%{PATH}(?:%{URIPARAM})?`,
+	"URI":               `[A-Za-z][A-Za-z0-9+.-]*://\S+`,
+	"QS":                `"(?:[^"\\]|\\.)*"`,
+	"QUOTEDSTRING":      `%{QS}`,
+	"MONTH":             `\b(?:[Jj]an(?:uary)?|[Ff]eb(?:ruary)?|[Mm]ar(?:ch)?|[Aa]pr(?:il)?|[Mm]ay|[Jj]un(?:e)?|[Jj]ul(?:y)?|[Aa]ug(?:ust)?|[Ss]ep(?:tember)?|[Oo]ct(?:ober)?|[Nn]ov(?:ember)?|[Dd]ec(?:ember)?)\b`,
+	"MONTHNUM":          `(?:0?[1-9]|1[0-2])`,
+	"MONTHDAY":          `(?:0[1-9]|[12]\d|3[01]|[1-9])`,
+	"YEAR":              `\d{4}`,
+	"HOUR":              `(?:2[0-3]|[01]?\d)`,
+	"MINUTE":            `[0-5]\d`,
+	"SECOND":            `(?:[0-5]?\d|60)(?:[.,]\d+)?`,
+	"TIME":              `%{HOUR}:%{MINUTE}(?::%{SECOND})?`,
+	"ISO8601_TIMEZONE":  `(?:Z|[+-]%{HOUR}(?::?%{MINUTE}))`,
+	"TIMESTAMP_ISO8601": `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?%{ISO8601_TIMEZONE}?`,
+	"HTTPDATE":          `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} [+-]\d{4}`,
+	"LOGLEVEL":          `(?i:trace|debug|info|notice|warn(?:ing)?|err(?:or)?|crit(?:ical)?|fatal|severe|emerg(?:ency)?|panic|alert)`,
+	"COMMONAPACHELOG": `%{IPORHOST:clientip} %{NOTSPACE:ident} %{NOTSPACE:auth} \[%{HTTPDATE:timestamp}\] ` +
+		`"(?:%{WORD:verb} %{NOTSPACE:request}(?: HTTP/%{NUMBER:httpversion})?|%{DATA:rawrequest})" ` +
+		`%{INT:response:int} (?:%{INT:bytes:int}|-)`,
+	"COMBINEDAPACHELOG": `%{COMMONAPACHELOG} %{QS:referrer} %{QS:agent}`,
+	// access log in combined format, used by nginx and apache body formats
+	"ACCESSLOG": `%{IPORHOST:remote_addr} %{NOTSPACE} %{NOTSPACE:remote_user} \[%{HTTPDATE:time_local}\] ` +
+		`"(?:%{WORD:method} %{NOTSPACE:path}(?: %{NOTSPACE:protocol})?|%{DATA:request})" ` +
+		`%{INT:status:int} (?:%{INT:bytes:int}|-) "%{DATA:referer}" "%{DATA:user_agent}"`,
+	// nginx combined format optionally followed by "$http_x_forwarded_for", $request_time and $upstream_response_time
+	"NGINXACCESS": `%{ACCESSLOG}(?: "%{DATA:x_forwarded_for}")?(?: %{NUMBER:request_time:float})?` +
+		`(?: (?P<upstream_response_time>[\d.]+(?:, [\d.]+)*))?`,
+	// apache combined format optionally followed by request time in microseconds (%D)
+	"APACHEACCESS": `%{ACCESSLOG}(?: %{INT:request_time_us:int})?`,
+}
+
+// grokMaxDepth limits nesting of patterns to detect recursive ones
+const grokMaxDepth = 32
+
+var grokReferenceRegexp = regexp.MustCompile(`%\{(\w+)(?::([\w.@-]+))?(?::(int|float))?\}`)
+
+// GrokRule applies grok Pattern to log of containers selected by Scope
+type GrokRule struct {
+	Scope
+	Pattern string
+}
+
+// ParseGrokRule parses rule from string '<namespace-regex>:<container-regex>:<pattern>'
+func ParseGrokRule(rule string, library map[string]string) (*GrokRule, error) {
+	parts := strings.SplitN(rule, ":", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid grok rule '%s', expected '<namespace-regex>:<container-regex>:<pattern>'", rule)
+	}
+	r := &GrokRule{Pattern: parts[2]}
+	var err error
+	if r.Scope, err = NewScope(parts[0], parts[1]); err != nil {
+		return nil, fmt.Errorf("invalid grok rule '%s', %w", rule, err)
+	}
+	if _, err = compileGrok(r.Pattern, library); err != nil {
+		return nil, fmt.Errorf("invalid grok rule '%s', %w", rule, err)
+	}
+	return r, nil
+}
+
+// FindGrokPatterns returns patterns of all rules matched by namespace and container name
+func FindGrokPatterns(rules []*GrokRule, namespace string, container string) []string {
+	var patterns []string
+	for _, r := range rules {
+		if r.Match(namespace, container) {
+			patterns = append(patterns, r.Pattern)
+		}
+	}
+	return patterns
+}
+
+// grokGroupPrefix prefixes names of regexp groups generated for %{NAME:field} references,
+// they are named by index in fields because field names may contain characters not allowed
+// in regexp group names. Other named groups are used as field names as is.
+const grokGroupPrefix = "_grok"
+
+// grokExpression is compiled grok pattern
+type grokExpression struct {
+	re     *regexp.Regexp
+	fields []grokField
+}
+
+type grokField struct {
+	name string
+	typ  string
+}
+
+// GrokProcessor parses log field by grok patterns, the first matched pattern is used
+type GrokProcessor struct {
+	expressions []*grokExpression
+}
+
+// NewGrokProcessor compiles patterns, library extends built-in GrokPatterns and overrides them
+func NewGrokProcessor(patterns []string, library map[string]string) (*GrokProcessor, error) {
+	p := &GrokProcessor{}
+	for _, pattern := range patterns {
+		e, err := compileGrok(pattern, library)
+		if err != nil {
+			return nil, err
+		}
+		p.expressions = append(p.expressions, e)
+	}
+	return p, nil
+}
+
+// Process puts named captures of the first matched pattern to record, log field removed
+// unless pattern captures it. Record without match is tagged with TagParseFailure.
+func (p *GrokProcessor) Process(record Properties) (Properties, error) {
+	body, ok := record["log"].(string)
+	if !ok {
+		return record, nil
+	}
+	body = strings.TrimSuffix(body, "\n")
+	for _, e := range p.expressions {
+		match := e.re.FindStringSubmatch(body)
+		if match == nil {
+			continue
+		}
+		delete(record, "log")
+		for i, group := range e.re.SubexpNames() {
+			if group == "" {
+				continue
+			}
+			f := grokField{name: group}
+			if strings.HasPrefix(group, grokGroupPrefix) {
+				k, _ := strconv.Atoi(strings.TrimPrefix(group, grokGroupPrefix))
+				f = e.fields[k]
+			}
+			if value, ok := convertGrokValue(match[i], f.typ); ok {
+				record[f.name] = value
+			}
+		}
+		return record, nil
+	}
+	AddTag(record, TagParseFailure)
+	return record, nil
+}
+
+// AddTag appends tag to tags field of record
+func AddTag(record Properties, tag string) {
+	switch tags := record[TagsKey].(type) {
+	case nil:
+		record[TagsKey] = []interface{}{tag}
+	case []interface{}:
+		record[TagsKey] = append(tags, tag)
+	default:
+		record[TagsKey] = []interface{}{tags, tag}
+	}
+}
+
+// convertGrokValue converts captured value to type, empty or unparsable values are skipped
+func convertGrokValue(value string, typ string) (interface{}, bool) {
+	switch typ {
+	case "int":
+		v, err := strconv.ParseInt(value, 10, 64)
+		return v, err == nil
+	case "float":
+		v, err := strconv.ParseFloat(value, 64)
+		return v, err == nil
+	}
+	return value, value != ""
+}
+
+// compileGrok expands %{NAME}, %{NAME:field} and %{NAME:field:int|float} references
+// to library patterns and compiles result
+func compileGrok(pattern string, library map[string]string) (*grokExpression, error) {
+	e := &grokExpression{}
+	expanded, err := e.expand(pattern, library, 0)
+	if err != nil {
+		return nil, err
+	}
+	e.re, err = regexp.Compile(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid grok pattern '%s', %w", pattern, err)
+	}
+	return e, nil
+}
+
+func (e *grokExpression) expand(pattern string, library map[string]string, depth int) (string, error) {
+	if depth > grokMaxDepth {
+		return "", fmt.Errorf("grok pattern '%s' is nested too deep", pattern)
+	}
+	var err error
+	expanded := grokReferenceRegexp.ReplaceAllStringFunc(pattern, func(ref string) string {
+		if err != nil {
+			return ""
+		}
+		parts := grokReferenceRegexp.FindStringSubmatch(ref)
+		name, field, typ := parts[1], parts[2], parts[3]
+		definition, ok := library[name]
+		if !ok {
+			definition, ok = GrokPatterns[name]
+		}
+		if !ok {
+			err = fmt.Errorf("unknown grok pattern '%s'", name)
+			return ""
+		}
+		var inner string
+		inner, err = e.expand(definition, library, depth+1)
+		if field == "" {
+			return "(?:" + inner + ")"
+		}
+		e.fields = append(e.fields, grokField{name: field, typ: typ})
+		return "(?P<" + grokGroupPrefix + strconv.Itoa(len(e.fields)-1) + ">" + inner + ")"
+	})
+	return expanded, err
+}
@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGrokRule(t *testing.T) {
+	library := map[string]string{"PGLEVEL": `%{WORD}`}
+	r, err := ParseGrokRule(`^db$:^postgres:%{TIMESTAMP_ISO8601:time} \[%{INT:pid:int}\] %{PGLEVEL:level}: %{GREEDYDATA:message}`, library)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{r.Pattern}, FindGrokPatterns([]*GrokRule{r}, "db", "postgres-0"))
+	assert.Nil(t, FindGrokPatterns([]*GrokRule{r}, "default", "postgres-0"))
+
+	_, err = ParseGrokRule(`::%{UNKNOWN:a}`, nil)
+	assert.Error(t, err)
+	_, err = ParseGrokRule(`::(`, nil)
+	assert.Error(t, err)
+	_, err = ParseGrokRule(`postgres`, nil)
+	assert.Error(t, err)
+	_, err = ParseGrokRule(`::%{LOOP}`, map[string]string{"LOOP": "%{LOOP}"})
+	assert.Error(t, err)
+}
+
+func TestGrokProcessor(t *testing.T) {
+	g, err := NewGrokProcessor([]string{
+		`%{COMBINEDAPACHELOG}`,
+		`^(?P<level>[A-Z]+) %{GREEDYDATA:app.message}$`,
+	}, nil)
+	assert.NoError(t, err)
+	p := NewPipeline(NewDockerDecoder(0, 0), []Processor{g, &JSONProcessor{}}, Properties{})
+
+	out, err := p.ParseLine(`{"log":"10.0.0.1 - bob [10/Oct/2020:13:55:36 +0300] \"GET /index.html?a=1 HTTP/1.1\" 200 2326 \"-\" \"curl/7.64\"\n"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"agent":"\"curl/7.64\"","auth":"bob","bytes":2326,"clientip":"10.0.0.1","httpversion":"1.1",`+
+		`"ident":"-","referrer":"\"-\"","request":"/index.html?a=1","response":200,"timestamp":"10/Oct/2020:13:55:36 +0300","verb":"GET"}`, out)
+
+	out, err = p.ParseLine(`{"log":"WARN disk is full\n"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"app.message":"disk is full","level":"WARN"}`, out)
+
+	out, err = p.ParseLine(`{"log":"something else\n"}`)
+	assert.Error(t, err)
+	assert.Equal(t, `{"log":"something else\n","tags":["_parse_failure"]}`, out)
+}
+
+func TestAddTag(t *testing.T) {
+	record := Properties{}
+	AddTag(record, "a")
+	AddTag(record, "b")
+	assert.Equal(t, []interface{}{"a", "b"}, record[TagsKey])
+	record = Properties{TagsKey: "app"}
+	AddTag(record, "a")
+	assert.Equal(t, []interface{}{"app", "a"}, record[TagsKey])
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	b, err := NewBodyProcessor(FormatNginx)
+	assert.NoError(t, err)
+	p := NewPipeline(NewDockerDecoder(0, 0), []Processor{b, NewCoerceProcessor([]CoerceRule{{Field: "upstream_response_time", Type: CoerceLast, Suffix: "_float"}})}, Properties{})
+	out, err := p.ParseLine(`{"log":"10.0.0.1 - - [10/Oct/2020:13:55:36 +0000] \"POST /api/v1/users HTTP/1.1\" 201 512 \"https://example.com/\" \"Mozilla/5.0 (X11)\" \"-\" 0.125 0.010, 0.100\n"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"bytes":512,"method":"POST","path":"/api/v1/users","protocol":"HTTP/1.1","referer":"https://example.com/",`+
+		`"remote_addr":"10.0.0.1","remote_user":"-","request_time":0.125,"status":201,"time_local":"10/Oct/2020:13:55:36 +0000",`+
+		`"upstream_response_time":"0.010, 0.100","upstream_response_time_float":0.1,"user_agent":"Mozilla/5.0 (X11)","x_forwarded_for":"-"}`, out)
+
+	b, err = NewBodyProcessor(FormatApache)
+	assert.NoError(t, err)
+	p = NewPipeline(NewDockerDecoder(0, 0), []Processor{b}, Properties{})
+	out, err = p.ParseLine(`{"log":"::1 - admin [10/Oct/2020:13:55:36 -0700] \"GET / HTTP/1.0\" 304 - \"-\" \"curl/7.64\" 1500\n"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"method":"GET","path":"/","protocol":"HTTP/1.0","referer":"-","remote_addr":"::1","remote_user":"admin",`+
+		`"request_time_us":1500,"status":304,"time_local":"10/Oct/2020:13:55:36 -0700","user_agent":"curl/7.64"}`, out)
+}
@@ -0,0 +1,50 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// klogSeverities maps klog severity letter to severity name
+var klogSeverities = map[string]string{
+	"I": "info",
+	"W": "warning",
+	"E": "error",
+	"F": "fatal",
+}
+
+// KlogProcessor parses kubernetes klog/glog header
+// 'Lmmdd hh:mm:ss.uuuuuu threadid file:line] msg' of log field
+type KlogProcessor struct {
+	re *regexp.Regexp
+}
+
+// NewKlogProcessor creates new KlogProcessor
+func NewKlogProcessor() *KlogProcessor {
+	return &KlogProcessor{
+		re: regexp.MustCompile(`(?s)^([IWEF])(\d{4} \d{2}:\d{2}:\d{2}\.\d+)\s+(\d+) ([^:\]\s]+):(\d+)\] ?(.*?)\n?$`),
+	}
+}
+
+// Process puts severity, klog_time, thread_id, source, source_file, source_line
+// and message to record, record which does not match is tagged with TagParseFailure
+func (p *KlogProcessor) Process(record Properties) (Properties, error) {
+	body, ok := record["log"].(string)
+	if !ok {
+		return record, nil
+	}
+	match := p.re.FindStringSubmatch(body)
+	if match == nil {
+		AddTag(record, TagParseFailure)
+		return record, nil
+	}
+	delete(record, "log")
+	record["severity"] = klogSeverities[match[1]]
+	record["klog_time"] = match[2]
+	record["thread_id"], _ = strconv.ParseInt(match[3], 10, 64)
+	record["source"] = match[4] + ":" + match[5]
+	record["source_file"] = match[4]
+	record["source_line"], _ = strconv.ParseInt(match[5], 10, 64)
+	record["message"] = match[6]
+	return record, nil
+}
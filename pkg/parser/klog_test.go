@@ -0,0 +1,22 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKlogProcessor(t *testing.T) {
+	b, err := NewBodyProcessor(FormatKlog)
+	assert.NoError(t, err)
+	p := NewPipeline(NewDockerDecoder(0, 0), []Processor{b}, Properties{})
+
+	out, err := p.ParseLine(`{"log":"W0102 15:04:05.000123    4321 reflector.go:424] watch of *v1.Pod ended\n","stream":"stderr"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"klog_time":"0102 15:04:05.000123","message":"watch of *v1.Pod ended","severity":"warning",`+
+		`"source":"reflector.go:424","source_file":"reflector.go","source_line":424,"stream":"stderr","thread_id":4321}`, out)
+
+	out, err = p.ParseLine(`{"log":"not a klog line\n"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"log":"not a klog line\n","tags":["_parse_failure"]}`, out)
+}
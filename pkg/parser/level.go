@@ -0,0 +1,96 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LevelKey name of field with normalized level
+const LevelKey = "level"
+
+// Normalized levels
+const (
+	LevelTrace = "trace"
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+	LevelFatal = "fatal"
+)
+
+// levelAliases maps lower cased level names used by different loggers to normalized levels
+var levelAliases = map[string]string{
+	"trace": LevelTrace, "trc": LevelTrace, "t": LevelTrace, "10": LevelTrace,
+	"debug": LevelDebug, "dbg": LevelDebug, "d": LevelDebug, "20": LevelDebug,
+	"info": LevelInfo, "inf": LevelInfo, "i": LevelInfo, "information": LevelInfo, "notice": LevelInfo, "30": LevelInfo,
+	"warn": LevelWarn, "warning": LevelWarn, "wrn": LevelWarn, "w": LevelWarn, "40": LevelWarn,
+	"error": LevelError, "err": LevelError, "eror": LevelError, "e": LevelError, "50": LevelError,
+	"fatal": LevelFatal, "critical": LevelFatal, "crit": LevelFatal, "panic": LevelFatal, "f": LevelFatal,
+	"emerg": LevelFatal, "emergency": LevelFatal, "alert": LevelFatal, "severe": LevelFatal, "60": LevelFatal,
+}
+
+// levelTextFields are fields with log text checked for level prefix
+var levelTextFields = []string{"log", "message", "msg"}
+
+// LevelProcessor detects level of record and writes it normalized to LevelKey field
+type LevelProcessor struct {
+	fields []string
+	prefix *regexp.Regexp
+}
+
+// NewLevelProcessor creates new LevelProcessor, fields are checked in order
+func NewLevelProcessor(fields []string) *LevelProcessor {
+	return &LevelProcessor{
+		fields: fields,
+		prefix: regexp.MustCompile(`^\W{0,2}(?i:(trace|debug|info|notice|warn(?:ing)?|err(?:or)?|fatal|crit(?:ical)?|panic)\b|([IWEF])\d{4} )`),
+	}
+}
+
+// Process detects level from configured fields, then from prefix of log text,
+// and falls back to error for stderr and info for stdout
+func (p *LevelProcessor) Process(record Properties) (Properties, error) {
+	if level := p.detect(record); level != "" {
+		record[LevelKey] = level
+	}
+	return record, nil
+}
+
+func (p *LevelProcessor) detect(record Properties) string {
+	for _, field := range p.fields {
+		if level := normalizeLevel(record[field]); level != "" {
+			return level
+		}
+	}
+	for _, field := range levelTextFields {
+		text, ok := record[field].(string)
+		if !ok {
+			continue
+		}
+		if match := p.prefix.FindStringSubmatch(text); match != nil {
+			return normalizeLevel(match[1] + match[2])
+		}
+	}
+	switch record["stream"] {
+	case "stderr":
+		return LevelError
+	case "stdout":
+		return LevelInfo
+	}
+	return ""
+}
+
+// normalizeLevel returns normalized level of value or empty string if value is not a known level
+func normalizeLevel(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return levelAliases[strings.ToLower(strings.TrimSpace(v))]
+	case float64:
+		if v == float64(int(v)) {
+			return levelAliases[strconv.Itoa(int(v))]
+		}
+	case int64:
+		return levelAliases[strconv.Itoa(int(v))]
+	}
+	return ""
+}
@@ -0,0 +1,33 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLevelProcessor(t *testing.T) {
+	p := NewLevelProcessor([]string{"level", "lvl", "severity", "L"})
+	cases := []struct {
+		record Properties
+		level  interface{}
+	}{
+		{Properties{"level": "WARNING", "stream": "stderr"}, LevelWarn},
+		{Properties{"lvl": "dbg"}, LevelDebug},
+		{Properties{"severity": "CRITICAL"}, LevelFatal},
+		{Properties{"L": "W"}, LevelWarn},
+		{Properties{"level": 50.0}, LevelError},
+		{Properties{"level": "unknown", "lvl": "info"}, LevelInfo},
+		{Properties{"log": "[ERROR] connection lost\n", "stream": "stdout"}, LevelError},
+		{Properties{"message": "Warn: disk is almost full"}, LevelWarn},
+		{Properties{"log": "E0102 15:04:05.000000 1 main.go:1] failed"}, LevelError},
+		{Properties{"log": "informational text", "stream": "stdout"}, LevelInfo},
+		{Properties{"log": "Traceback (most recent call last):", "stream": "stderr"}, LevelError},
+		{Properties{"log": "no stream"}, nil},
+	}
+	for _, c := range cases {
+		record, err := p.Process(c.record)
+		assert.NoError(t, err)
+		assert.Equal(t, c.level, record[LevelKey], "%v", c.record)
+	}
+}
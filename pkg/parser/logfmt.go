@@ -0,0 +1,198 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Body formats used by body processor
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+	// FormatAuto detects JSON or logfmt body, other bodies are kept as is
+	FormatAuto = "auto"
+	// FormatNginx is nginx access log in combined format
+	FormatNginx = "nginx"
+	// FormatApache is apache access log in combined format
+	FormatApache = "apache"
+	// FormatKlog is kubernetes klog/glog format
+	FormatKlog = "klog"
+)
+
+// FormatRule selects body format for containers matched by Container regex
+type FormatRule struct {
+	Container *regexp.Regexp
+	Format    string
+}
+
+// ParseFormatRule parses rule from string '<container-regex>:<format>'
+func ParseFormatRule(rule string) (*FormatRule, error) {
+	i := strings.LastIndex(rule, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid body format rule '%s', expected '<container-regex>:<format>'", rule)
+	}
+	container, err := regexp.Compile(rule[:i])
+	if err != nil {
+		return nil, fmt.Errorf("invalid body format rule '%s', %w", rule, err)
+	}
+	r := &FormatRule{Container: container, Format: rule[i+1:]}
+	if _, err = NewBodyProcessor(r.Format); err != nil {
+		return nil, fmt.Errorf("invalid body format rule '%s', %w", rule, err)
+	}
+	return r, nil
+}
+
+// FindFormat returns format of the first rule matched by container name or defaultFormat
+func FindFormat(rules []*FormatRule, container string, defaultFormat string) string {
+	for _, r := range rules {
+		if r.Container.MatchString(container) {
+			return r.Format
+		}
+	}
+	return defaultFormat
+}
+
+// NewBodyProcessor returns processor which parses log field in format
+func NewBodyProcessor(format string) (Processor, error) {
+	return newBodyProcessor(format, "")
+}
+
+// newBodyProcessor creates body processor which puts fields colliding with runtime fields under prefix
+func newBodyProcessor(format string, prefix string) (Processor, error) {
+	switch format {
+	case FormatJSON, "":
+		return &JSONProcessor{Prefix: prefix}, nil
+	case FormatLogfmt:
+		return &LogfmtProcessor{Prefix: prefix}, nil
+	case FormatAuto:
+		return &AutoProcessor{json: JSONProcessor{Prefix: prefix}, logfmt: LogfmtProcessor{Prefix: prefix}}, nil
+	case FormatNginx:
+		return NewGrokProcessor([]string{`^%{NGINXACCESS}`}, nil)
+	case FormatApache:
+		return NewGrokProcessor([]string{`^%{APACHEACCESS}`}, nil)
+	case FormatKlog:
+		return NewKlogProcessor(), nil
+	}
+	return nil, fmt.Errorf("unknown body format '%s'", format)
+}
+
+// LogfmtProcessor parses log field in logfmt format (key=value key2="quoted value")
+// and puts its fields to record, keys without values are set to true
+type LogfmtProcessor struct {
+	// Prefix is added to fields which collide with fields already present in record,
+	// they are overwritten if Prefix is empty
+	Prefix string
+}
+
+// Process decodes logfmt from log field, log field removed on success
+func (p *LogfmtProcessor) Process(record Properties) (Properties, error) {
+	body, ok := record["log"].(string)
+	if !ok {
+		return record, nil
+	}
+	fields, err := parseLogfmt(body)
+	if err != nil {
+		return record, err
+	}
+	delete(record, "log")
+	mergeBody(record, fields, p.Prefix)
+	return record, nil
+}
+
+// AutoProcessor parses log field as JSON object or logfmt, depending on content,
+// log field is kept as is if it is neither of them
+type AutoProcessor struct {
+	json   JSONProcessor
+	logfmt LogfmtProcessor
+}
+
+// Process detects format of log field and decodes it
+func (p *AutoProcessor) Process(record Properties) (Properties, error) {
+	body, _ := record["log"].(string)
+	body = strings.TrimSpace(body)
+	if strings.HasPrefix(body, "{") {
+		if out, err := p.json.Process(record); err == nil {
+			return out, nil
+		}
+		return record, nil
+	}
+	if isLogfmt(body) {
+		if out, err := p.logfmt.Process(record); err == nil {
+			return out, nil
+		}
+	}
+	return record, nil
+}
+
+var logfmtStartRegexp = regexp.MustCompile(`^[^\s="]+=`)
+
+// isLogfmt checks that line starts with key=value pair
+func isLogfmt(line string) bool {
+	return logfmtStartRegexp.MatchString(line)
+}
+
+var errNoLogfmtPairs = errors.New("no key=value pairs found in logfmt line")
+
+// parseLogfmt parses logfmt line, line without any key=value pair considered invalid
+func parseLogfmt(line string) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	pairs := 0
+	i := 0
+	for {
+		for i < len(line) && isLogfmtSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			break
+		}
+		start := i
+		for i < len(line) && !isLogfmtSpace(line[i]) && line[i] != '=' && line[i] != '"' {
+			i++
+		}
+		key := line[start:i]
+		if key == "" {
+			return nil, fmt.Errorf("unexpected '%c' at position %d of logfmt line", line[i], i)
+		}
+		if i >= len(line) || line[i] != '=' {
+			fields[key] = true
+			continue
+		}
+		i++ // skip '='
+		pairs++
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("unterminated quoted value of key '%s' in logfmt line", key)
+			}
+			value, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted value of key '%s' in logfmt line, %w", key, err)
+			}
+			fields[key] = value
+			i = end + 1
+			continue
+		}
+		start = i
+		for i < len(line) && !isLogfmtSpace(line[i]) {
+			i++
+		}
+		fields[key] = line[start:i]
+	}
+	if pairs == 0 {
+		return nil, errNoLogfmtPairs
+	}
+	return fields, nil
+}
+
+func isLogfmtSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
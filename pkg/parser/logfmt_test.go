@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	fields, err := parseLogfmt(`level=info msg="request \"done\"" duration=1.5s empty= debug` + "\n")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"level":    "info",
+		"msg":      `request "done"`,
+		"duration": "1.5s",
+		"empty":    "",
+		"debug":    true,
+	}, fields)
+
+	_, err = parseLogfmt("just some text")
+	assert.Error(t, err)
+	_, err = parseLogfmt(`msg="unterminated`)
+	assert.Error(t, err)
+	_, err = parseLogfmt(`a=1 "b"=2`)
+	assert.Error(t, err)
+}
+
+func TestParseFormatRule(t *testing.T) {
+	r, err := ParseFormatRule("^go-.*:logfmt")
+	assert.NoError(t, err)
+	assert.Equal(t, FormatLogfmt, r.Format)
+	assert.Equal(t, FormatLogfmt, FindFormat([]*FormatRule{r}, "go-api", FormatJSON))
+	assert.Equal(t, FormatJSON, FindFormat([]*FormatRule{r}, "java-api", FormatJSON))
+
+	_, err = ParseFormatRule("^go-.*:xml")
+	assert.Error(t, err)
+	_, err = ParseFormatRule("logfmt")
+	assert.Error(t, err)
+	_, err = ParseFormatRule("(:logfmt")
+	assert.Error(t, err)
+}
+
+func TestBodyProcessor(t *testing.T) {
+	processors, err := NewProcessors(&Options{
+		Processors: []string{ProcessorBody, ProcessorFlatten},
+		BodyFormat: FormatLogfmt,
+	})
+	assert.NoError(t, err)
+	p := NewPipeline(NewDockerDecoder(0, 0), processors, Properties{})
+	out, err := p.ParseLine(`{"log":"level=info msg=\"user logged in\" user.id=42\n","stream":"stdout"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"level":"info","msg":"user logged in","stream":"stdout","user.id":"42"}`, out)
+	out, err = p.ParseLine(`{"log":"plain text\n","stream":"stdout"}`)
+	assert.Error(t, err)
+	assert.Equal(t, `{"log":"plain text\n","stream":"stdout"}`, out)
+
+	processors, err = NewProcessors(&Options{
+		Processors: []string{ProcessorBody, ProcessorFlatten},
+		BodyFormat: FormatAuto,
+	})
+	assert.NoError(t, err)
+	p = NewPipeline(NewDockerDecoder(0, 0), processors, Properties{})
+	out, err = p.ParseLine(`{"log":"{\"user\":{\"id\":42}}\n"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"user.id":42}`, out)
+	out, err = p.ParseLine(`{"log":"level=warn msg=slow\n"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"level":"warn","msg":"slow"}`, out)
+	out, err = p.ParseLine(`{"log":"I0919 12:00:00 started, a=b\n"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"log":"I0919 12:00:00 started, a=b\n"}`, out)
+
+	_, err = NewProcessors(&Options{Processors: []string{ProcessorBody}, BodyFormat: "xml"})
+	assert.Error(t, err)
+}
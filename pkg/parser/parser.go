@@ -1,118 +1,218 @@
 package parser
 
-// ParseLine used to parse docker log string, and try to parse inner log
+// Parser is a pipeline used to parse log lines of any container runtime:
+// runtime specific Decoder turns line into record, then ordered chain of
+// Processors transforms record, and finally record extended with properties.
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
-	"reflect"
-	"strconv"
-	"strings"
 )
 
+// Fields of records decoded from truncated lines
+const (
+	TruncatedKey      = "_truncated"
+	OriginalLengthKey = "_original_length"
+)
+
+// OffsetKey internal field with offset of line in log file which record is decoded from,
+// it is removed before record is serialized
+const OffsetKey = "_offset"
+
+// reservedKeys are fields set by decoder or parser, app fields do not overwrite them
+// and move processor does not move them
+var reservedKeys = map[string]bool{OffsetKey: true, TruncatedKey: true, OriginalLengthKey: true}
+
 type Properties map[string]interface{}
 
-// Parser parse json and extend it with data
+// PropertiesReceiver implemented by processors which need parser properties
+type PropertiesReceiver interface {
+	SetProperties(p Properties)
+}
+
+// StopReceiver implemented by processors which wait inside Process, stop channel of reader interrupts waiting
+type StopReceiver interface {
+	SetStop(stop <-chan bool)
+}
+
+// OffsetReceiver implemented by decoders which hold records, offset of line is set before it is decoded
+type OffsetReceiver interface {
+	SetOffset(offset int64)
+}
+
+// Holder implemented by decoders and processors which hold records between calls
+type Holder interface {
+	Held() []Properties
+}
+
+// Parser parse log lines and extend them with data
 type Parser struct {
+	decoder    Decoder
+	processors []Processor
 	properties Properties
+	schema     Schema
+	offset     int64
 }
 
-// New creats new parser
+// New creats new parser for docker json-file logs with default processors
 func New(p Properties) *Parser {
+	return NewPipeline(NewDockerDecoder(0, 0), DefaultProcessors(), p)
+}
+
+// NewPipeline creates new parser with decoder and processors chain
+func NewPipeline(d Decoder, processors []Processor, p Properties) *Parser {
+	for _, processor := range processors {
+		if r, ok := processor.(PropertiesReceiver); ok {
+			r.SetProperties(p)
+		}
+	}
 	return &Parser{
+		decoder:    d,
+		processors: processors,
 		properties: p,
 	}
 }
 
-// ParseLine parse JSON string and put all fields to upper json
-func (p *Parser) ParseLine(line string) (string, error) {
-	var i map[string]interface{}
-	err := json.Unmarshal([]byte(line), &i)
-	if err != nil {
-		return line, fmt.Errorf("Unable to parse input %s, due to err %s", line, err)
-	}
-	var inner interface{}
-	err = json.Unmarshal([]byte(i["log"].(string)), &inner)
-	if err != nil {
-		out, ierr := p.extend(i)
-		if ierr != nil {
-			return line, ierr
+// SetStop passes stop channel of reader to processors
+func (p *Parser) SetStop(stop <-chan bool) {
+	for _, processor := range p.processors {
+		if r, ok := processor.(StopReceiver); ok {
+			r.SetStop(stop)
 		}
-		return out, err
 	}
+}
 
-	innerMap, ok := inner.(map[string]interface{})
-	if !ok {
-		out, ierr := p.extend(i)
-		if ierr != nil {
-			return line, ierr
-		}
-		return out, err
+// SetOffset sets offset of the next parsed line in log file
+func (p *Parser) SetOffset(offset int64) {
+	p.offset = offset
+	if r, ok := p.decoder.(OffsetReceiver); ok {
+		r.SetOffset(offset)
 	}
-	for key, value := range innerMap {
-		if value == nil {
-			// INFO reflect.TypeOf(nil).Kind() cause panic so check nil here
-			i[key] = nil
-			continue
-		}
-
-		if key == "upstream_response_time" {
-			var floatValue float64
-			var terr = errors.New("Not nil")
-
-			if reflect.TypeOf(value).Kind() == reflect.Float64 {
-				floatValue, terr = value.(float64), nil
-			}
-			if reflect.TypeOf(value).Kind() == reflect.String {
-				floatValue, terr = transformValue(value.(string))
-			}
+}
 
-			if terr == nil {
-				i["upstream_response_time_float"] = floatValue
-			}
+// PendingOffset returns offset of the first line held by decoder or processors,
+// false if nothing is held
+func (p *Parser) PendingOffset() (int64, bool) {
+	var min int64
+	found := false
+	check := func(h interface{}) {
+		holder, ok := h.(Holder)
+		if !ok {
+			return
 		}
-
-		if reflect.TypeOf(value).Kind() == reflect.Map {
-			if value, ok := value.(map[string]interface{}); ok {
-				for k, v := range value {
-					if v == nil {
-						i[strings.Join([]string{key, k}, ".")] = nil
-						continue
-					}
-					if reflect.TypeOf(v).Kind() == reflect.Map {
-						// We don't want to parse recursive yet
-						continue
-					}
-					i[strings.Join([]string{key, k}, ".")] = v
-				}
-				continue
+		for _, record := range holder.Held() {
+			if offset, ok := record[OffsetKey].(int64); ok && (!found || offset < min) {
+				min, found = offset, true
 			}
-			continue
 		}
-
-		i[key] = value
 	}
-	delete(i, "log")
-	out, err := p.extend(i)
+	check(p.decoder)
+	for _, processor := range p.processors {
+		check(processor)
+	}
+	return min, found
+}
+
+// ParseLine decodes line, processes record and returns it serialized to JSON.
+// Empty output means line buffered or dropped and there is nothing to send.
+// Error of processor does not stop processing, it returned together with output.
+func (p *Parser) ParseLine(line string) (string, error) {
+	record, err := p.decoder.Decode(line)
 	if err != nil {
 		return line, err
 	}
-	return string(out), nil
+	if record == nil {
+		return "", nil
+	}
+	return p.processLine(line, record)
+}
+
+// ParseTruncatedLine decodes the beginning of line which is too long to be read whole,
+// record marked with TruncatedKey and length of the whole line in OriginalLengthKey
+func (p *Parser) ParseTruncatedLine(line string, length int) (string, error) {
+	d, ok := p.decoder.(TruncatedDecoder)
+	if !ok {
+		return "", fmt.Errorf("decoder does not support truncated lines")
+	}
+	record, err := d.DecodeTruncated(line)
+	if err != nil {
+		return "", err
+	}
+	record[TruncatedKey] = true
+	record[OriginalLengthKey] = length
+	return p.processLine(line, record)
+}
+
+// processLine processes decoded record of line and serializes it
+func (p *Parser) processLine(line string, record Properties) (string, error) {
+	if _, ok := record[OffsetKey]; !ok {
+		record[OffsetKey] = p.offset
+	}
+	record, err := p.process(record, 0)
+	if record == nil {
+		return "", err
+	}
+	out, xerr := p.extend(record)
+	if xerr != nil {
+		return line, xerr
+	}
+	return out, err
+}
 
+// Flush returns records buffered by decoder and processors, force flushes not completed ones too
+func (p *Parser) Flush(force bool) []string {
+	var output []string
+	add := func(record Properties, from int) {
+		record, _ = p.process(record, from)
+		if record == nil {
+			return
+		}
+		if out, err := p.extend(record); err == nil {
+			output = append(output, out)
+		}
+	}
+	for _, record := range p.decoder.Flush(force) {
+		add(record, 0)
+	}
+	for k, processor := range p.processors {
+		if f, ok := processor.(Flusher); ok {
+			if record := f.Flush(force); record != nil {
+				add(record, k+1)
+			}
+		}
+	}
+	return output
 }
 
-func transformValue(value string) (float64, error) {
-	value = strings.Replace(value, " ", "", -1)
-	values := strings.Split(value, ",")
+// process runs processors starting from processor number from,
+// returns nil record if one of processors dropped (or buffered) it
+func (p *Parser) process(record Properties, from int) (Properties, error) {
+	var firstErr error
+	for _, processor := range p.processors[from:] {
+		var err error
+		record, err = processor.Process(record)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if record == nil {
+			return nil, firstErr
+		}
+	}
+	return record, firstErr
+}
 
-	lastValue := values[len(values)-1]
-	return strconv.ParseFloat(lastValue, 64)
+// SetSchema sets output schema, records are sent as they are if it is not set
+func (p *Parser) SetSchema(s Schema) {
+	p.schema = s
 }
 
 func (p *Parser) extend(a Properties) (string, error) {
+	delete(a, OffsetKey)
 	for k, v := range p.properties {
 		a[k] = v
 	}
+	if p.schema != nil {
+		a = p.schema.Map(a)
+	}
 	out, err := json.Marshal(a)
 	if err != nil {
 		return "", err
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -54,7 +55,7 @@ func TestParser(t *testing.T) {
 		"log": "{\"hello\":\"world\",\"data\":{\"a\":1,\"b\":{\"a\":1}},\"a\": 1,\"b\": null}"
 		}`)
 	assert.NoError(t, err)
-	assert.Equal(t, "{\"a\":1,\"b\":null,\"data.a\":1,\"dc\":\"nsk\",\"hello\":\"world\"}", out)
+	assert.Equal(t, "{\"a\":1,\"b\":null,\"data.a\":1,\"data.b.a\":1,\"dc\":\"nsk\",\"hello\":\"world\"}", out)
 
 	out, err = p.ParseLine(`{
 		"log": "{\"hello\":\"world\",\"data\":{\"a\":1,\"b\":null},\"a\": 1,\"b\": null}"
@@ -122,3 +123,118 @@ func TestParseUpstreamResponseTime(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "{\"upstream_response_time.value\":1.142}", out)
 }
+
+func TestParsePartial(t *testing.T) {
+	p := NewPipeline(NewDockerDecoder(1024, time.Minute), DefaultProcessors(), Properties{})
+	out, err := p.ParseLine(`{"log":"{\"hello\":","stream":"stdout"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "", out)
+	out, err = p.ParseLine(`{"log":"\"world\",","stream":"stdout"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "", out)
+	out, err = p.ParseLine(`{"log":"\"a\":1}\n","stream":"stdout"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":1,"hello":"world","stream":"stdout"}`, out)
+
+	out, err = p.ParseLine(`{"log":"not partial\n"}`)
+	assert.Error(t, err)
+	assert.Equal(t, `{"log":"not partial\n"}`, out)
+
+	// Partial entries of stdout and stderr are interleaved
+	p = NewPipeline(NewDockerDecoder(1024, time.Minute), []Processor{}, Properties{})
+	out, _ = p.ParseLine(`{"log":"out ","stream":"stdout"}`)
+	assert.Equal(t, "", out)
+	out, _ = p.ParseLine(`{"log":"err ","stream":"stderr"}`)
+	assert.Equal(t, "", out)
+	out, _ = p.ParseLine(`{"log":"line\n","stream":"stdout"}`)
+	assert.Equal(t, `{"log":"out line\n","stream":"stdout"}`, out)
+	out, _ = p.ParseLine(`{"log":"line\n","stream":"stderr"}`)
+	assert.Equal(t, `{"log":"err line\n","stream":"stderr"}`, out)
+
+	// Size limit reached, line is truncated and the rest of its entries is discarded
+	d := NewDockerDecoder(4, time.Minute)
+	p = NewPipeline(d, []Processor{}, Properties{})
+	out, _ = p.ParseLine(`{"log":"ab"}`)
+	assert.Equal(t, "", out)
+	out, _ = p.ParseLine(`{"log":"cd"}`)
+	assert.Equal(t, "", out)
+	out, _ = p.ParseLine(`{"log":"ef"}`)
+	assert.Equal(t, "", out)
+	out, _ = p.ParseLine(`{"log":"g\n"}`)
+	assert.Equal(t, `{"_original_length":8,"_truncated":true,"log":"abcd"}`, out)
+	out, _ = p.ParseLine(`{"log":"next\n"}`)
+	assert.Equal(t, `{"log":"next\n"}`, out)
+	out, _ = p.ParseLine(`{"log":"ab"}`)
+	assert.Equal(t, "", out)
+	out, _ = p.ParseLine(`{"log":"cdef"}`)
+	assert.Equal(t, "", out)
+	assert.Nil(t, p.Flush(false))
+	assert.Equal(t, []string{`{"_original_length":6,"_truncated":true,"log":"abcd"}`}, p.Flush(true))
+	assert.Nil(t, p.Flush(true))
+
+	// Line is cut on rune boundary
+	p = NewPipeline(NewDockerDecoder(4, time.Minute), []Processor{}, Properties{})
+	p.ParseLine(`{"log":"abc"}`)
+	out, _ = p.ParseLine(`{"log":"é\n"}`)
+	assert.Equal(t, `{"_original_length":6,"_truncated":true,"log":"abc"}`, out)
+
+	// Oversized line is dropped
+	d = NewDockerDecoder(4, time.Minute)
+	d.SetDropOversized(true)
+	p = NewPipeline(d, []Processor{}, Properties{})
+	p.ParseLine(`{"log":"abc"}`)
+	out, _ = p.ParseLine(`{"log":"def\n"}`)
+	assert.Equal(t, "", out)
+	out, _ = p.ParseLine(`{"log":"a\n"}`)
+	assert.Equal(t, `{"log":"a\n"}`, out)
+
+	// Last part did not arrive in time, for example container is killed
+	p = NewPipeline(NewDockerDecoder(1024, 10*time.Millisecond), DefaultProcessors(), Properties{})
+	out, _ = p.ParseLine(`{"log":"killed in the mid","stream":"stdout"}`)
+	assert.Equal(t, "", out)
+	assert.Nil(t, p.Flush(false))
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, []string{`{"log":"killed in the mid","stream":"stdout"}`}, p.Flush(false))
+	assert.Nil(t, p.Flush(false))
+}
+
+func TestParseTruncatedLine(t *testing.T) {
+	p := NewPipeline(NewDockerDecoder(1024, time.Minute), []Processor{}, Properties{"dc": "nsk"})
+	out, err := p.ParseTruncatedLine(`{"log":"café \"quoted\" \u00e`, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"_original_length":100,"_truncated":true,"dc":"nsk","log":"café \"quoted\" "}`, out)
+	_, err = p.ParseTruncatedLine(`{"stream":"stdout","log":"a`, 100)
+	assert.Error(t, err)
+}
+
+func TestPendingOffset(t *testing.T) {
+	dedup, _ := NewDedupProcessor(DedupOptions{Window: time.Hour})
+	p := NewPipeline(NewDockerDecoder(1024, time.Minute), []Processor{dedup}, Properties{})
+	_, ok := p.PendingOffset()
+	assert.False(t, ok)
+
+	p.SetOffset(0)
+	out, _ := p.ParseLine(`{"log":"a\n"}`)
+	assert.Equal(t, "", out)
+	offset, ok := p.PendingOffset()
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), offset)
+
+	// Partial entry held by decoder
+	p.SetOffset(14)
+	out, _ = p.ParseLine(`{"log":"b"}`)
+	assert.Equal(t, "", out)
+	offset, _ = p.PendingOffset()
+	assert.Equal(t, int64(0), offset)
+
+	// Whole line releases record held by dedup, offset is not sent
+	p.SetOffset(26)
+	out, _ = p.ParseLine(`{"log":"c\n"}`)
+	assert.Equal(t, `{"log":"a\n"}`, out)
+	offset, _ = p.PendingOffset()
+	assert.Equal(t, int64(14), offset)
+
+	assert.Equal(t, []string{`{"log":"bc\n"}`}, p.Flush(true))
+	_, ok = p.PendingOffset()
+	assert.False(t, ok)
+}
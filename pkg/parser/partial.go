@@ -0,0 +1,118 @@
+package parser
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Partials reassembles lines which runtime splits into several partial entries,
+// entries of each stream (stdout, stderr) are joined separately. Line longer than
+// maxBytes is truncated and marked with TruncatedKey and OriginalLengthKey (or dropped),
+// the rest of its entries is discarded till the last one.
+type Partials struct {
+	lines    map[string]*partialLine
+	maxBytes int
+	timeout  time.Duration
+	drop     bool
+}
+
+// partialLine store partial entries of one stream till the last entry arrives
+type partialLine struct {
+	record    Properties
+	log       strings.Builder
+	length    int
+	truncated bool
+	updated   time.Time
+}
+
+// NewPartials creates new Partials, line is sent when its last entry arrived or
+// did not arrive during timeout
+func NewPartials(maxBytes int, timeout time.Duration) *Partials {
+	return &Partials{
+		lines:    make(map[string]*partialLine),
+		maxBytes: maxBytes,
+		timeout:  timeout,
+	}
+}
+
+// SetDropOversized makes lines longer than maxBytes dropped instead of truncated
+func (p *Partials) SetDropOversized(drop bool) {
+	p.drop = drop
+}
+
+// Join buffers partial entry of record stream and returns whole line when its last
+// entry arrived, otherwise nil. Nil is returned for dropped oversized line too.
+func (p *Partials) Join(i Properties, partial bool) Properties {
+	stream, _ := i["stream"].(string)
+	logLine, _ := i["log"].(string)
+	pl, ok := p.lines[stream]
+	if !ok {
+		if !partial {
+			return i
+		}
+		pl = &partialLine{record: i}
+		p.lines[stream] = pl
+	}
+	pl.length += len(logLine)
+	pl.updated = time.Now()
+	if !pl.truncated {
+		if rest := p.maxBytes - pl.log.Len(); len(logLine) > rest {
+			// Cut on rune boundary, so truncated line stays valid UTF-8
+			for rest > 0 && !utf8.RuneStart(logLine[rest]) {
+				rest--
+			}
+			logLine, pl.truncated = logLine[:rest], true
+		}
+		pl.log.WriteString(logLine)
+	}
+	if partial {
+		return nil
+	}
+	return p.flush(stream)
+}
+
+// Held returns the first entries of buffered lines
+func (p *Partials) Held() []Properties {
+	var records []Properties
+	for _, pl := range p.lines {
+		records = append(records, pl.record)
+	}
+	return records
+}
+
+// Flush returns lines which last entry did not arrive in time, or all of them if force is true
+func (p *Partials) Flush(force bool) []Properties {
+	streams := make([]string, 0, len(p.lines))
+	for stream, pl := range p.lines {
+		if force || time.Since(pl.updated) >= p.timeout {
+			streams = append(streams, stream)
+		}
+	}
+	sort.Strings(streams)
+	var records []Properties
+	for _, stream := range streams {
+		if record := p.flush(stream); record != nil {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// flush returns buffered line of stream, nil if line is oversized and dropped
+func (p *Partials) flush(stream string) Properties {
+	pl := p.lines[stream]
+	delete(p.lines, stream)
+	if pl.truncated {
+		if p.drop {
+			log.Printf("Drop partial line of %d bytes, it is longer than %d bytes", pl.length, p.maxBytes)
+			return nil
+		}
+		pl.record[TruncatedKey] = true
+		pl.record[OriginalLengthKey] = pl.length
+	}
+	pl.record["log"] = pl.log.String()
+	return pl.record
+}
@@ -0,0 +1,430 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Processor transforms decoded record. Nil record means processor dropped
+// (or buffered) it and next processors should not be called.
+// Error does not stop processing, record returned with error is processed further.
+type Processor interface {
+	Process(record Properties) (Properties, error)
+}
+
+// Flusher implemented by processors which buffer records between Process calls
+type Flusher interface {
+	Flush(force bool) Properties
+}
+
+// Field is a pair of field name and value, used to keep order of configured fields
+type Field struct {
+	Key   string
+	Value string
+}
+
+// ParseField parses field from string 'key=value'
+func ParseField(s string) (Field, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return Field{}, fmt.Errorf("invalid field '%s', expected 'key=value'", s)
+	}
+	return Field{Key: parts[0], Value: parts[1]}, nil
+}
+
+// Processor names used in Options.Processors
+const (
+	ProcessorJSON      = "json"
+	ProcessorLogfmt    = "logfmt"
+	ProcessorBody      = "body"
+	ProcessorFlatten   = "flatten"
+	ProcessorCoerce    = "coerce"
+	ProcessorTimestamp = "timestamp"
+	ProcessorLevel     = "level"
+	ProcessorRedact    = "redact"
+	ProcessorRename    = "rename"
+	ProcessorDrop      = "drop"
+	ProcessorAddFields = "add-fields"
+	ProcessorMove      = "move"
+)
+
+// Options configure processors chain
+type Options struct {
+	// Processors is ordered list of processor names
+	Processors []string
+	// BodyFormat is format of log field parsed by body processor: json (default), logfmt or auto
+	BodyFormat string
+	// Rename is ordered list of renames, Key is old field name and Value is new one
+	Rename []Field
+	// Drop is list of field names or globs to remove from record
+	Drop []string
+	// Add is ordered list of fields added to record, values may be templates with ${field}
+	Add []Field
+	// Move configures move processor
+	Move MoveOptions
+	// Flatten configures flatten processor
+	Flatten FlattenOptions
+	// Coerce is ordered list of type coercion rules
+	Coerce []CoerceRule
+	// Timestamp configures timestamp processor
+	Timestamp TimestampOptions
+	// LevelFields are fields checked by level processor in order
+	LevelFields []string
+	// Redact configures redact processor
+	Redact RedactOptions
+}
+
+// Array policies of flatten processor
+const (
+	// ArraysKeep arrays are kept as is
+	ArraysKeep = "keep"
+	// ArraysJoin arrays are joined to comma separated string
+	ArraysJoin = "join"
+	// ArraysIndex array elements are flattened with index as key
+	ArraysIndex = "index"
+)
+
+// FlattenOptions configure flatten processor
+type FlattenOptions struct {
+	// Separator joins keys of nested objects, default is '.'
+	Separator string
+	// MaxDepth limits how deep nested objects are flattened, 0 means unlimited
+	MaxDepth int
+	// KeepNested keeps objects deeper than MaxDepth as is, otherwise they are dropped
+	KeepNested bool
+	// Arrays is a policy for arrays: keep (default), join or index
+	Arrays string
+}
+
+// DefaultProcessors returns processors used when nothing configured:
+// inner JSON decoding, flattening and upstream_response_time coercion
+func DefaultProcessors() []Processor {
+	rule, _ := ParseCoerceRule(DefaultCoerceRule)
+	processors, _ := NewProcessors(&Options{
+		Processors: []string{ProcessorJSON, ProcessorFlatten, ProcessorCoerce},
+		Coerce:     []CoerceRule{rule},
+	})
+	return processors
+}
+
+// NewProcessors creates processors chain by options
+func NewProcessors(o *Options) ([]Processor, error) {
+	var processors []Processor
+	// App fields colliding with runtime fields are moved when body is decoded,
+	// move processor can not tell them apart later
+	conflictPrefix := ""
+	for _, name := range o.Processors {
+		if name == ProcessorMove {
+			conflictPrefix = o.Move.Prefix
+		}
+	}
+	for _, name := range o.Processors {
+		switch name {
+		case ProcessorJSON:
+			processors = append(processors, &JSONProcessor{Prefix: conflictPrefix})
+		case ProcessorLogfmt:
+			processors = append(processors, &LogfmtProcessor{Prefix: conflictPrefix})
+		case ProcessorBody:
+			b, err := newBodyProcessor(o.BodyFormat, conflictPrefix)
+			if err != nil {
+				return nil, err
+			}
+			processors = append(processors, b)
+		case ProcessorFlatten:
+			f, err := NewFlattenProcessor(o.Flatten)
+			if err != nil {
+				return nil, err
+			}
+			processors = append(processors, f)
+		case ProcessorCoerce:
+			processors = append(processors, NewCoerceProcessor(o.Coerce))
+		case ProcessorTimestamp:
+			processors = append(processors, NewTimestampProcessor(o.Timestamp))
+		case ProcessorLevel:
+			processors = append(processors, NewLevelProcessor(o.LevelFields))
+		case ProcessorRedact:
+			r, err := NewRedactProcessor(o.Redact)
+			if err != nil {
+				return nil, err
+			}
+			processors = append(processors, r)
+		case ProcessorRename:
+			processors = append(processors, &RenameProcessor{fields: o.Rename})
+		case ProcessorDrop:
+			for _, glob := range o.Drop {
+				if _, err := path.Match(glob, ""); err != nil {
+					return nil, fmt.Errorf("invalid drop field '%s', %w", glob, err)
+				}
+			}
+			processors = append(processors, &DropProcessor{fields: o.Drop})
+		case ProcessorAddFields:
+			processors = append(processors, &AddFieldsProcessor{fields: o.Add})
+		case ProcessorMove:
+			processors = append(processors, &MoveProcessor{o: o.Move})
+		default:
+			return nil, fmt.Errorf("unknown processor '%s'", name)
+		}
+	}
+	return processors, nil
+}
+
+// JSONProcessor parses log field as JSON and puts its fields to record
+type JSONProcessor struct {
+	// Prefix is added to fields which collide with fields already present in record,
+	// they are overwritten if Prefix is empty
+	Prefix string
+}
+
+// Process decodes JSON object from log field, log field removed on success
+func (p *JSONProcessor) Process(record Properties) (Properties, error) {
+	body, ok := record["log"].(string)
+	if !ok {
+		return record, nil
+	}
+	var inner interface{}
+	err := json.Unmarshal([]byte(body), &inner)
+	if err != nil {
+		return record, err
+	}
+	innerMap, ok := inner.(map[string]interface{})
+	if !ok {
+		return record, nil
+	}
+	delete(record, "log")
+	mergeBody(record, innerMap, p.Prefix)
+	return record, nil
+}
+
+// mergeBody puts fields decoded from log body to record, if prefix is not empty fields
+// colliding with runtime fields (time, stream, log) are put under prefix. Reserved fields
+// (offset, truncation marker) are never overwritten, app fields with their names are dropped
+// if prefix is empty.
+func mergeBody(record Properties, fields map[string]interface{}, prefix string) {
+	for key, value := range fields {
+		if reservedKeys[key] {
+			// Reserved field of app is kept only under prefix
+			if prefix == "" {
+				continue
+			}
+			key = prefix + key
+		} else if _, ok := record[key]; ok && prefix != "" {
+			key = prefix + key
+		}
+		record[key] = value
+	}
+}
+
+// FlattenProcessor puts fields of nested objects to record with joined keys
+type FlattenProcessor struct {
+	o FlattenOptions
+}
+
+// NewFlattenProcessor creates new FlattenProcessor, empty options replaced by defaults
+func NewFlattenProcessor(o FlattenOptions) (*FlattenProcessor, error) {
+	if o.Separator == "" {
+		o.Separator = "."
+	}
+	switch o.Arrays {
+	case "":
+		o.Arrays = ArraysKeep
+	case ArraysKeep, ArraysJoin, ArraysIndex:
+	default:
+		return nil, fmt.Errorf("unknown flatten arrays policy '%s'", o.Arrays)
+	}
+	return &FlattenProcessor{o: o}, nil
+}
+
+// Process flattens nested objects (and arrays if configured) recursively
+func (p *FlattenProcessor) Process(record Properties) (Properties, error) {
+	keys := make([]string, 0, len(record))
+	for key := range record {
+		keys = append(keys, key)
+	}
+	// Keys sorted so result does not depend on map order when flattened keys collide
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch record[key].(type) {
+		case map[string]interface{}, []interface{}:
+			value := record[key]
+			delete(record, key)
+			p.flatten(record, key, value, 0)
+		}
+	}
+	return record, nil
+}
+
+// flatten puts value to record by key, depth is nesting level of value
+func (p *FlattenProcessor) flatten(record Properties, key string, value interface{}, depth int) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if p.o.MaxDepth > 0 && depth >= p.o.MaxDepth {
+			if p.o.KeepNested {
+				record[key] = v
+			}
+			return
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p.flatten(record, key+p.o.Separator+k, v[k], depth+1)
+		}
+	case []interface{}:
+		switch p.o.Arrays {
+		case ArraysJoin:
+			record[key] = joinArray(v)
+		case ArraysIndex:
+			if p.o.MaxDepth > 0 && depth >= p.o.MaxDepth {
+				if p.o.KeepNested {
+					record[key] = v
+				}
+				return
+			}
+			for i, nested := range v {
+				p.flatten(record, key+p.o.Separator+strconv.Itoa(i), nested, depth+1)
+			}
+		default:
+			record[key] = v
+		}
+	default:
+		record[key] = value
+	}
+}
+
+// joinArray joins array elements to comma separated string, objects are serialized to JSON
+func joinArray(array []interface{}) string {
+	values := make([]string, 0, len(array))
+	for _, value := range array {
+		switch v := value.(type) {
+		case string:
+			values = append(values, v)
+		case map[string]interface{}, []interface{}:
+			out, _ := json.Marshal(v)
+			values = append(values, string(out))
+		case nil:
+			values = append(values, "")
+		default:
+			values = append(values, fmt.Sprint(v))
+		}
+	}
+	return strings.Join(values, ",")
+}
+
+// RenameProcessor renames fields in configured order
+type RenameProcessor struct {
+	fields []Field
+}
+
+// Process renames fields which are present in record
+func (p *RenameProcessor) Process(record Properties) (Properties, error) {
+	for _, f := range p.fields {
+		if value, ok := record[f.Key]; ok {
+			delete(record, f.Key)
+			record[f.Value] = value
+		}
+	}
+	return record, nil
+}
+
+// DropProcessor removes fields matched by names or globs from record
+type DropProcessor struct {
+	fields []string
+}
+
+// Process removes configured fields
+func (p *DropProcessor) Process(record Properties) (Properties, error) {
+	for _, field := range p.fields {
+		for _, key := range matchFields(record, field) {
+			delete(record, key)
+		}
+	}
+	return record, nil
+}
+
+var fieldTemplateRegexp = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// AddFieldsProcessor adds fields to record, value may be a template where ${field}
+// is replaced by value of record field or parser property, missing fields replaced by empty string
+type AddFieldsProcessor struct {
+	fields     []Field
+	properties Properties
+}
+
+// SetProperties sets parser properties available to templates
+func (p *AddFieldsProcessor) SetProperties(properties Properties) {
+	p.properties = properties
+}
+
+// Process adds configured fields, existing fields are overwritten
+func (p *AddFieldsProcessor) Process(record Properties) (Properties, error) {
+	for _, f := range p.fields {
+		record[f.Key] = fieldTemplateRegexp.ReplaceAllStringFunc(f.Value, func(ref string) string {
+			key := ref[2 : len(ref)-1]
+			value, ok := record[key]
+			if !ok {
+				value, ok = p.properties[key]
+			}
+			if !ok || value == nil {
+				return ""
+			}
+			return fmt.Sprint(value)
+		})
+	}
+	return record, nil
+}
+
+// DefaultMoveExclude are fields which are not moved by move processor by default
+var DefaultMoveExclude = []string{"log", "stream", RuntimeTimeKey, TimestampKey, LevelKey, TagsKey}
+
+// MoveOptions configure move processor
+type MoveOptions struct {
+	// Prefix added to names of moved fields, for example 'app.'
+	Prefix string
+	// Exclude are names or globs of fields which are not moved
+	Exclude []string
+}
+
+// MoveProcessor moves app fields under prefix, so they do not collide with fields
+// added by runtime decoder and parser properties
+type MoveProcessor struct {
+	o MoveOptions
+}
+
+// Process renames all fields except excluded ones and ones which already have prefix to prefix + name
+func (p *MoveProcessor) Process(record Properties) (Properties, error) {
+	if p.o.Prefix == "" {
+		return record, nil
+	}
+	keys := make([]string, 0, len(record))
+	for key := range record {
+		if !p.excluded(key) && !strings.HasPrefix(key, p.o.Prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := record[key]
+		delete(record, key)
+		record[p.o.Prefix+key] = value
+	}
+	return record, nil
+}
+
+func (p *MoveProcessor) excluded(key string) bool {
+	if reservedKeys[key] {
+		return true
+	}
+	for _, glob := range p.o.Exclude {
+		if ok, _ := path.Match(glob, key); ok {
+			return true
+		}
+	}
+	return false
+}
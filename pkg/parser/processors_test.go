@@ -0,0 +1,144 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseField(t *testing.T) {
+	f, err := ParseField("a=b=c")
+	assert.NoError(t, err)
+	assert.Equal(t, Field{Key: "a", Value: "b=c"}, f)
+	_, err = ParseField("a")
+	assert.Error(t, err)
+	_, err = ParseField("=a")
+	assert.Error(t, err)
+}
+
+func TestNewProcessors(t *testing.T) {
+	_, err := NewProcessors(&Options{Processors: []string{"json", "unknown"}})
+	assert.Error(t, err)
+
+	processors, err := NewProcessors(&Options{
+		Processors: []string{ProcessorJSON, ProcessorRename, ProcessorDrop, ProcessorAddFields},
+		Rename:     []Field{{Key: "time", Value: "app_time"}, {Key: "app_time", Value: "renamed_time"}},
+		Drop:       []string{"password", "unknown"},
+		Add:        []Field{{Key: "env", Value: "prod"}, {Key: "stream", Value: "overwritten"}},
+	})
+	assert.NoError(t, err)
+	p := NewPipeline(NewDockerDecoder(0, 0), processors, Properties{"dc": "nsk"})
+	out, err := p.ParseLine(`{"log":"{\"time\":\"now\",\"password\":\"secret\",\"a\":{\"b\":1}}","stream":"stdout"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":{"b":1},"dc":"nsk","env":"prod","renamed_time":"now","stream":"overwritten"}`, out)
+	assert.Nil(t, p.Flush(true))
+}
+
+type dropProcessor struct{}
+
+func (p *dropProcessor) Process(record Properties) (Properties, error) {
+	return nil, nil
+}
+
+func TestPipelineDrop(t *testing.T) {
+	processors := append(DefaultProcessors(), &dropProcessor{})
+	p := NewPipeline(NewDockerDecoder(0, 0), processors, Properties{})
+	out, err := p.ParseLine(`{"log":"{\"a\":1}"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "", out)
+}
+
+func TestFlattenProcessor(t *testing.T) {
+	_, err := NewFlattenProcessor(FlattenOptions{Arrays: "unknown"})
+	assert.Error(t, err)
+
+	record := func() Properties {
+		return Properties{
+			"a": map[string]interface{}{
+				"b": map[string]interface{}{"c": 1.0},
+				"d": nil,
+			},
+			"list": []interface{}{"x", 1.0, map[string]interface{}{"y": true}},
+			"e":    "f",
+		}
+	}
+
+	p, _ := NewFlattenProcessor(FlattenOptions{})
+	out, err := p.Process(record())
+	assert.NoError(t, err)
+	assert.Equal(t, Properties{
+		"a.b.c": 1.0,
+		"a.d":   nil,
+		"list":  []interface{}{"x", 1.0, map[string]interface{}{"y": true}},
+		"e":     "f",
+	}, out)
+
+	p, _ = NewFlattenProcessor(FlattenOptions{Separator: "_", MaxDepth: 1, Arrays: ArraysJoin})
+	out, _ = p.Process(record())
+	assert.Equal(t, Properties{"a_d": nil, "list": `x,1,{"y":true}`, "e": "f"}, out)
+
+	p, _ = NewFlattenProcessor(FlattenOptions{MaxDepth: 1, KeepNested: true, Arrays: ArraysIndex})
+	out, _ = p.Process(record())
+	assert.Equal(t, Properties{
+		"a.b":    map[string]interface{}{"c": 1.0},
+		"a.d":    nil,
+		"list.0": "x",
+		"list.1": 1.0,
+		"list.2": map[string]interface{}{"y": true},
+		"e":      "f",
+	}, out)
+
+	p, _ = NewFlattenProcessor(FlattenOptions{Arrays: ArraysIndex})
+	out, _ = p.Process(record())
+	assert.Equal(t, true, out["list.2.y"])
+}
+
+func TestFieldProcessors(t *testing.T) {
+	_, err := NewProcessors(&Options{Processors: []string{ProcessorDrop}, Drop: []string{"["}})
+	assert.Error(t, err)
+
+	processors, err := NewProcessors(&Options{
+		Processors: []string{ProcessorJSON, ProcessorDrop, ProcessorMove, ProcessorRename, ProcessorAddFields},
+		Drop:       []string{"debug_*"},
+		Move:       MoveOptions{Prefix: "app.", Exclude: DefaultMoveExclude},
+		Rename:     []Field{{Key: "app.user", Value: "app.user_id"}},
+		Add: []Field{
+			{Key: "service", Value: "${kubernetes.container_name}-${app.namespace}"},
+			{Key: "missing", Value: "${unknown}"},
+		},
+	})
+	assert.NoError(t, err)
+	p := NewPipeline(NewDockerDecoder(0, 0), processors, Properties{"namespace": "prod", "kubernetes.container_name": "api"})
+	// App fields colliding with runtime time and stream are moved, runtime fields are kept
+	out, err := p.ParseLine(`{"log":"{\"namespace\":\"billing\",\"user\":42,\"time\":\"now\",\"stream\":\"audit\",` +
+		`\"debug_a\":1,\"debug_b\":2,\"level\":\"info\"}","stream":"stdout","time":"2020-01-02T03:04:05Z"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"app.namespace":"billing","app.stream":"audit","app.time":"now","app.user_id":42,`+
+		`"kubernetes.container_name":"api","level":"info","missing":"","namespace":"prod","service":"api-billing",`+
+		`"stream":"stdout","time":"2020-01-02T03:04:05Z"}`, out)
+
+	// Reserved fields are not overwritten by app and not moved
+	record, _ := (&JSONProcessor{Prefix: "app."}).Process(Properties{
+		"log": `{"_offset":0,"_truncated":true,"a":1}`, OffsetKey: int64(10)})
+	assert.Equal(t, Properties{OffsetKey: int64(10), "app._offset": 0.0, "app._truncated": true, "a": 1.0}, record)
+	record, _ = (&LogfmtProcessor{}).Process(Properties{"log": `_offset=0 a=1`, OffsetKey: int64(10)})
+	assert.Equal(t, Properties{OffsetKey: int64(10), "a": "1"}, record)
+	record, _ = (&MoveProcessor{o: MoveOptions{Prefix: "app."}}).Process(Properties{
+		OffsetKey: int64(10), TruncatedKey: true, OriginalLengthKey: 100, "a": 1.0})
+	assert.Equal(t, Properties{OffsetKey: int64(10), TruncatedKey: true, OriginalLengthKey: 100, "app.a": 1.0}, record)
+
+	// Without move processor app fields overwrite runtime fields as before
+	p = NewPipeline(NewDockerDecoder(0, 0), []Processor{&JSONProcessor{}}, nil)
+	out, err = p.ParseLine(`{"log":"{\"time\":\"now\"}","stream":"stdout","time":"2020-01-02T03:04:05Z"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"stream":"stdout","time":"now"}`, out)
+}
+
+func TestFlattenDeterministic(t *testing.T) {
+	f, _ := NewFlattenProcessor(FlattenOptions{})
+	// Colliding keys give the same result on every run, map iteration order does not matter
+	for i := 0; i < 20; i++ {
+		record, _ := f.Process(Properties{"a": map[string]interface{}{"b": 1.0}, "a.b": 2.0, "c": map[string]interface{}{"d": map[string]interface{}{"e": 1.0}, "d.e": 2.0}})
+		assert.Equal(t, Properties{"a.b": 1.0, "c.d.e": 2.0}, record)
+	}
+}
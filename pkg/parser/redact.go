@@ -0,0 +1,258 @@
+package parser
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"rvadim/loggo/pkg/metrics"
+)
+
+// Redaction actions
+const (
+	// RedactMask replaces sensitive value with RedactMaskValue
+	RedactMask = "mask"
+	// RedactHash replaces sensitive value with HMAC-SHA256 of it, so equal values can be correlated
+	RedactHash = "hash"
+	// RedactDrop removes field which contains sensitive value
+	RedactDrop = "drop"
+)
+
+// RedactMaskValue replaces sensitive values by mask action
+const RedactMaskValue = "[REDACTED]"
+
+// Built-in detectors
+const (
+	DetectorToken = "token"
+	DetectorAWS   = "aws"
+	DetectorEmail = "email"
+	DetectorCard  = "card"
+	DetectorIP    = "ip"
+)
+
+// DefaultRedactDetectors are all built-in detectors in order they are applied
+var DefaultRedactDetectors = []string{DetectorToken, DetectorAWS, DetectorEmail, DetectorCard, DetectorIP}
+
+type redactDetector struct {
+	name  string
+	re    *regexp.Regexp
+	valid func(match string) bool
+}
+
+var builtinDetectors = map[string]*redactDetector{
+	DetectorToken: {
+		name: DetectorToken,
+		re:   regexp.MustCompile(`(?i:bearer\s+[A-Za-z0-9\-._~+/]+=*)|\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	},
+	DetectorAWS: {
+		name: DetectorAWS,
+		re:   regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b|(?i:aws_?secret_?access_?key)["']?\s*[=:]\s*["']?[A-Za-z0-9/+=]{40}`),
+	},
+	DetectorEmail: {
+		name: DetectorEmail,
+		re:   regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	},
+	DetectorCard: {
+		name:  DetectorCard,
+		re:    regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		valid: isCardNumber,
+	},
+	DetectorIP: {
+		name: DetectorIP,
+		re:   regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|\b(?:[0-9A-Fa-f]{1,4}:){2,7}(?::|[0-9A-Fa-f]{1,4})\b|::1\b`),
+		valid: func(match string) bool {
+			return net.ParseIP(match) != nil
+		},
+	},
+}
+
+// RedactOptions configure redact processor
+type RedactOptions struct {
+	// Detectors are names of built-in detectors applied in order
+	Detectors []string
+	// Custom are named regexes applied after built-in detectors, Key is name and Value is regex
+	Custom []Field
+	// Action is mask (default), hash or drop
+	Action string
+	// Key of HMAC used by hash action
+	Key string
+	// Fields are names or globs of fields to redact, all string fields are redacted if empty
+	Fields []string
+}
+
+// RedactProcessor removes sensitive values from record fields
+type RedactProcessor struct {
+	o         RedactOptions
+	detectors []*redactDetector
+}
+
+// NewRedactProcessor creates new RedactProcessor
+func NewRedactProcessor(o RedactOptions) (*RedactProcessor, error) {
+	p := &RedactProcessor{o: o}
+	switch p.o.Action {
+	case "":
+		p.o.Action = RedactMask
+	case RedactMask, RedactDrop:
+	case RedactHash:
+		if o.Key == "" {
+			return nil, fmt.Errorf("redact hash action requires key")
+		}
+	default:
+		return nil, fmt.Errorf("unknown redact action '%s'", o.Action)
+	}
+	for _, name := range o.Detectors {
+		d, ok := builtinDetectors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown redact detector '%s'", name)
+		}
+		p.detectors = append(p.detectors, d)
+	}
+	for _, f := range o.Custom {
+		re, err := regexp.Compile(f.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern '%s', %w", f.Key, err)
+		}
+		p.detectors = append(p.detectors, &redactDetector{name: f.Key, re: re})
+	}
+	for _, glob := range o.Fields {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid redact field '%s', %w", glob, err)
+		}
+	}
+	return p, nil
+}
+
+// Process redacts string fields of record
+func (p *RedactProcessor) Process(record Properties) (Properties, error) {
+	for _, key := range p.fields(record) {
+		value, ok := record[key].(string)
+		if !ok {
+			continue
+		}
+		for _, d := range p.detectors {
+			if p.o.Action == RedactDrop {
+				if d.find(value) {
+					delete(record, key)
+					metrics.RedactionsCount.WithLabelValues(d.name, p.o.Action).Inc()
+					break
+				}
+				continue
+			}
+			value = d.re.ReplaceAllStringFunc(value, func(match string) string {
+				if d.valid != nil && !d.valid(match) {
+					return match
+				}
+				metrics.RedactionsCount.WithLabelValues(d.name, p.o.Action).Inc()
+				return p.replace(match)
+			})
+		}
+		if _, ok := record[key]; ok {
+			record[key] = value
+		}
+	}
+	return record, nil
+}
+
+// fields returns sorted names of fields to redact
+func (p *RedactProcessor) fields(record Properties) []string {
+	if len(p.o.Fields) == 0 {
+		keys := make([]string, 0, len(record))
+		for key := range record {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	var keys []string
+	for _, glob := range p.o.Fields {
+		keys = append(keys, matchFields(record, glob)...)
+	}
+	return keys
+}
+
+func (p *RedactProcessor) replace(match string) string {
+	if p.o.Action == RedactHash {
+		mac := hmac.New(sha256.New, []byte(p.o.Key))
+		mac.Write([]byte(match))
+		return "hmac:" + hex.EncodeToString(mac.Sum(nil))
+	}
+	return RedactMaskValue
+}
+
+// find checks that value contains valid match of detector
+func (d *redactDetector) find(value string) bool {
+	for _, match := range d.re.FindAllString(value, -1) {
+		if d.valid == nil || d.valid(match) {
+			return true
+		}
+	}
+	return false
+}
+
+// cardBrands are IIN prefix ranges and number lengths of major card brands,
+// digit runs of other prefixes or lengths (order ids, epoch timestamps, trace ids) are not cards
+var cardBrands = []struct {
+	from, to int
+	lengths  []int
+}{
+	{4, 4, []int{13, 16}},           // Visa
+	{51, 55, []int{16}},             // Mastercard
+	{2221, 2720, []int{16}},         // Mastercard 2-series
+	{34, 34, []int{15}},             // American Express
+	{37, 37, []int{15}},             // American Express
+	{6011, 6011, []int{16}},         // Discover
+	{644, 649, []int{16}},           // Discover
+	{65, 65, []int{16}},             // Discover
+	{3528, 3589, []int{16}},         // JCB
+	{300, 305, []int{14}},           // Diners Club
+	{36, 36, []int{14}},             // Diners Club
+	{38, 39, []int{14}},             // Diners Club
+	{62, 62, []int{16, 17, 18, 19}}, // UnionPay
+}
+
+// isCardNumber checks that digits (spaces and dashes ignored) have IIN prefix and length
+// of known card brand and pass Luhn check
+func isCardNumber(match string) bool {
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(match)
+	for _, brand := range cardBrands {
+		n := len(strconv.Itoa(brand.from))
+		prefix, _ := strconv.Atoi(digits[:n])
+		if prefix < brand.from || prefix > brand.to {
+			continue
+		}
+		for _, length := range brand.lengths {
+			if len(digits) == length {
+				return isLuhnValid(digits)
+			}
+		}
+	}
+	return false
+}
+
+// isLuhnValid checks card number (spaces and dashes ignored) by Luhn algorithm
+func isLuhnValid(number string) bool {
+	sum, digits := 0, 0
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		n := int(c - '0')
+		if digits%2 == 1 {
+			n *= 2
+			if n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+		digits++
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
@@ -0,0 +1,93 @@
+package parser
+
+import "fmt"
+
+// Output schemas
+const (
+	// SchemaLogstash is flat logstash-style layout, records are sent as they are
+	SchemaLogstash = "logstash"
+	// SchemaECS is Elastic Common Schema layout
+	SchemaECS = "ecs"
+)
+
+// ECSVersion is version of Elastic Common Schema written to ecs.version field
+const ECSVersion = "1.6.0"
+
+// Schema maps record to output layout right before it is serialized
+type Schema interface {
+	Map(record Properties) Properties
+}
+
+// NewSchema returns schema by name
+func NewSchema(name string) (Schema, error) {
+	switch name {
+	case SchemaLogstash, "":
+		return &LogstashSchema{}, nil
+	case SchemaECS:
+		return &ECSSchema{}, nil
+	}
+	return nil, fmt.Errorf("unknown output schema '%s'", name)
+}
+
+// LogstashSchema keeps record as is
+type LogstashSchema struct{}
+
+// Map returns record as is
+func (s *LogstashSchema) Map(record Properties) Properties {
+	return record
+}
+
+// ecsFields maps loggo fields to ECS fields
+var ecsFields = map[string]string{
+	"kubernetes.pod_name":        "kubernetes.pod.name",
+	"kubernetes.namespace_name":  "kubernetes.namespace",
+	"kubernetes.container_name":  "kubernetes.container.name",
+	"kubernetes.node_hostname":   "host.name",
+	"kubernetes.pod_id":          "kubernetes.pod.uid",
+	"kubernetes.pod_ip":          "kubernetes.pod.ip",
+	"kubernetes.container_image": "container.image.name",
+	"container_id":               "container.id",
+	"stream":                     "log.origin",
+	LevelKey:                     "log.level",
+	"dc":                         "labels.dc",
+	"purpose":                    "labels.purpose",
+	"type":                       "labels.type",
+	"logstash_prefix":            "labels.logstash_prefix",
+}
+
+// ecsDropped are fields duplicating other ones
+var ecsDropped = []string{"namespace"}
+
+// ecsMessageFields are fields with message, the first present one becomes message
+var ecsMessageFields = []string{"message", "log", "msg"}
+
+// ECSSchema maps loggo fields to Elastic Common Schema, fields unknown to ECS are kept as is
+type ECSSchema struct{}
+
+// Map renames known fields to ECS ones
+func (s *ECSSchema) Map(record Properties) Properties {
+	for _, field := range ecsMessageFields {
+		if value, ok := record[field]; ok {
+			delete(record, field)
+			record["message"] = value
+			break
+		}
+	}
+	if value, ok := record[RuntimeTimeKey]; ok {
+		delete(record, RuntimeTimeKey)
+		if _, ok := record[TimestampKey]; !ok {
+			record[TimestampKey] = value
+		}
+	}
+	for _, field := range ecsDropped {
+		delete(record, field)
+	}
+	for field, ecsField := range ecsFields {
+		if value, ok := record[field]; ok {
+			delete(record, field)
+			record[ecsField] = value
+		}
+	}
+	record["ecs.version"] = ECSVersion
+	return record
+}
@@ -0,0 +1,60 @@
+package parser
+
+import (
+	"bufio"
+	"flag"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestNewSchema(t *testing.T) {
+	_, err := NewSchema("gelf")
+	assert.Error(t, err)
+}
+
+// TestSchemaGolden parses testdata/schema_input.log and compares output with golden file
+// of each schema, run 'go test ./pkg/parser -run TestSchemaGolden -update' to update them
+func TestSchemaGolden(t *testing.T) {
+	for _, name := range []string{SchemaLogstash, SchemaECS} {
+		schema, err := NewSchema(name)
+		assert.NoError(t, err)
+		p := New(Properties{
+			"kubernetes.pod_name":       "api-7d9f-x2x",
+			"kubernetes.namespace_name": "prod",
+			"namespace":                 "prod",
+			"kubernetes.container_name": "api",
+			"kubernetes.node_hostname":  "node-1",
+			"container_id":              "3f2b",
+			"dc":                        "nsk",
+			"purpose":                   "production",
+			"type":                      "kubernetes",
+			"logstash_prefix":           "k8s",
+		})
+		p.SetSchema(schema)
+
+		input, err := os.Open("testdata/schema_input.log")
+		assert.NoError(t, err)
+		var output []string
+		scanner := bufio.NewScanner(input)
+		for scanner.Scan() {
+			out, _ := p.ParseLine(scanner.Text())
+			output = append(output, out)
+		}
+		input.Close()
+
+		golden := "testdata/schema_" + name + ".golden"
+		actual := strings.Join(output, "\n") + "\n"
+		if *update {
+			assert.NoError(t, ioutil.WriteFile(golden, []byte(actual), 0644))
+		}
+		expected, err := ioutil.ReadFile(golden)
+		assert.NoError(t, err)
+		assert.Equal(t, string(expected), actual, name)
+	}
+}
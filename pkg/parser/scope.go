@@ -0,0 +1,28 @@
+package parser
+
+import "regexp"
+
+// Scope selects containers of rule by Namespace and Container regexes,
+// empty regex matches any namespace or container
+type Scope struct {
+	Namespace *regexp.Regexp
+	Container *regexp.Regexp
+}
+
+// NewScope compiles namespace and container regexes of rule
+func NewScope(namespace string, container string) (Scope, error) {
+	var s Scope
+	var err error
+	if s.Namespace, err = regexp.Compile(namespace); err != nil {
+		return Scope{}, err
+	}
+	if s.Container, err = regexp.Compile(container); err != nil {
+		return Scope{}, err
+	}
+	return s, nil
+}
+
+// Match checks that container of namespace is selected by scope
+func (s Scope) Match(namespace string, container string) bool {
+	return s.Namespace.MatchString(namespace) && s.Container.MatchString(container)
+}
@@ -0,0 +1,18 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScope(t *testing.T) {
+	s, err := NewScope("^prod$", "")
+	assert.NoError(t, err)
+	assert.True(t, s.Match("prod", "app"))
+	assert.False(t, s.Match("dev", "app"))
+	_, err = NewScope("(", "")
+	assert.Error(t, err)
+	_, err = NewScope("", "(")
+	assert.Error(t, err)
+}
@@ -0,0 +1,144 @@
+package parser
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimestampKey name of field with normalized timestamp
+const TimestampKey = "@timestamp"
+
+// TagTimestampFailure added to tags of record which timestamp field could not be parsed
+const TagTimestampFailure = "_timestamp_failure"
+
+// RuntimeTimeKey name of field with time written by container runtime
+const RuntimeTimeKey = "time"
+
+// DefaultTimestampLayouts are tried before configured layouts, unix time numbers are detected separately
+var DefaultTimestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05,999999999",
+	"02/Jan/2006:15:04:05 -0700",
+}
+
+// TimestampOptions configure timestamp processor
+type TimestampOptions struct {
+	// Fields are app fields with time, the first present one is used
+	Fields []string
+	// Layouts are additional time layouts in Go format
+	Layouts []string
+	// PreferApp uses app time instead of runtime time when both are present
+	PreferApp bool
+}
+
+// TimestampProcessor writes time of record as RFC3339Nano in UTC to TimestampKey field
+type TimestampProcessor struct {
+	o       TimestampOptions
+	layouts []string
+}
+
+// NewTimestampProcessor creates new TimestampProcessor
+func NewTimestampProcessor(o TimestampOptions) *TimestampProcessor {
+	return &TimestampProcessor{
+		o:       o,
+		layouts: append(append([]string{}, DefaultTimestampLayouts...), o.Layouts...),
+	}
+}
+
+// Process parses runtime and app time, the preferred one is used if it is parsed,
+// record with time field which could not be parsed is tagged with TagTimestampFailure
+func (p *TimestampProcessor) Process(record Properties) (Properties, error) {
+	runtime, runtimeOk, runtimeFailed := p.parseField(record, RuntimeTimeKey)
+	var app time.Time
+	appOk, appFailed := false, false
+	for _, field := range p.o.Fields {
+		if _, present := record[field]; !present {
+			continue
+		}
+		app, appOk, appFailed = p.parseField(record, field)
+		break
+	}
+	switch {
+	case appOk && (p.o.PreferApp || !runtimeOk):
+		record[TimestampKey] = app.UTC().Format(time.RFC3339Nano)
+	case runtimeOk:
+		record[TimestampKey] = runtime.UTC().Format(time.RFC3339Nano)
+	}
+	if appFailed || runtimeFailed {
+		AddTag(record, TagTimestampFailure)
+	}
+	return record, nil
+}
+
+// parseField returns parsed time of field, ok if it parsed and failed if field present but not parsed
+func (p *TimestampProcessor) parseField(record Properties, field string) (t time.Time, ok bool, failed bool) {
+	value, present := record[field]
+	if !present {
+		return t, false, false
+	}
+	t, ok = p.parse(value)
+	return t, ok, !ok
+}
+
+func (p *TimestampProcessor) parse(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case float64:
+		return unixTime(v), true
+	case int64:
+		return unixTimeInt(v), true
+	case string:
+		v = strings.TrimSpace(v)
+		if number, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return unixTimeInt(number), true
+		}
+		if number, err := strconv.ParseFloat(v, 64); err == nil {
+			return unixTime(number), true
+		}
+		for _, layout := range p.layouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// unixTimeInt converts unix time in seconds, milliseconds, microseconds or nanoseconds
+// (detected by magnitude) to time
+func unixTimeInt(value int64) time.Time {
+	abs := value
+	if abs < 0 {
+		abs = -abs
+	}
+	switch {
+	case abs >= 1e17:
+		return time.Unix(0, value)
+	case abs >= 1e14:
+		return time.Unix(0, value*1e3)
+	case abs >= 1e11:
+		return time.Unix(0, value*1e6)
+	}
+	return time.Unix(value, 0)
+}
+
+// unixTime converts fractional unix time to time like unixTimeInt
+func unixTime(value float64) time.Time {
+	if value == math.Trunc(value) && math.Abs(value) < math.MaxInt64 {
+		return unixTimeInt(int64(value))
+	}
+	switch abs := math.Abs(value); {
+	case abs >= 1e17:
+		return time.Unix(0, int64(value))
+	case abs >= 1e14:
+		return time.Unix(0, int64(value*1e3))
+	case abs >= 1e11:
+		return time.Unix(0, int64(value*1e6))
+	}
+	sec, frac := math.Modf(value)
+	return time.Unix(int64(sec), int64(math.Round(frac*1e9)))
+}
@@ -0,0 +1,36 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimestampProcessor(t *testing.T) {
+	p := NewTimestampProcessor(TimestampOptions{Fields: []string{"ts", "timestamp"}, Layouts: []string{"Jan 2 2006 15:04:05"}})
+	record, _ := p.Process(Properties{"time": "2020-09-10T07:00:03.585507743+03:00", "ts": "Sep 10 2020 04:00:04"})
+	assert.Equal(t, "2020-09-10T04:00:03.585507743Z", record[TimestampKey])
+	assert.Nil(t, record[TagsKey])
+
+	record, _ = p.Process(Properties{"ts": 1599710403.5})
+	assert.Equal(t, "2020-09-10T04:00:03.5Z", record[TimestampKey])
+	record, _ = p.Process(Properties{"timestamp": "1599710403123"})
+	assert.Equal(t, "2020-09-10T04:00:03.123Z", record[TimestampKey])
+	record, _ = p.Process(Properties{"timestamp": "2020-09-10 04:00:03,250"})
+	assert.Equal(t, "2020-09-10T04:00:03.25Z", record[TimestampKey])
+
+	record, _ = p.Process(Properties{"time": "2020-09-10T07:00:03Z", "ts": "yesterday"})
+	assert.Equal(t, "2020-09-10T07:00:03Z", record[TimestampKey])
+	assert.Equal(t, []interface{}{TagTimestampFailure}, record[TagsKey])
+
+	record, _ = p.Process(Properties{"log": "no time"})
+	assert.Nil(t, record[TimestampKey])
+	assert.Nil(t, record[TagsKey])
+
+	p = NewTimestampProcessor(TimestampOptions{Fields: []string{"ts"}, PreferApp: true})
+	record, _ = p.Process(Properties{"time": "2020-09-10T07:00:03Z", "ts": "2020-09-10T06:59:59.1Z"})
+	assert.Equal(t, "2020-09-10T06:59:59.1Z", record[TimestampKey])
+	record, _ = p.Process(Properties{"time": "2020-09-10T07:00:03Z", "ts": "bad"})
+	assert.Equal(t, "2020-09-10T07:00:03Z", record[TimestampKey])
+	assert.Equal(t, []interface{}{TagTimestampFailure}, record[TagsKey])
+}
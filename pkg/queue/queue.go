@@ -0,0 +1,248 @@
+package queue
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"rvadim/loggo/pkg/delivery"
+	"rvadim/loggo/pkg/metrics"
+	"rvadim/loggo/pkg/transport"
+)
+
+// ErrQueueFull returned by DeliverMessages when queue reached its size limits
+var ErrQueueFull = errors.New("delivery queue is full")
+
+// Queue is a disk backed write-ahead buffer between readers and transport.
+// Readers append parsed batches with DeliverMessages, which returns as soon as
+// the batch is stored on disk, and the delivery worker drains stored batches
+// to the underlying transport in the same order.
+// Batch is removed from disk only after successful delivery, so undelivered
+// batches survive restarts and crashes (delivery is at least once).
+type Queue struct {
+	path       string
+	db         *bolt.DB
+	bucketName []byte
+	t          transport.ITransportClient
+	maxBytes   int64
+	maxBatches int
+	backoff    *delivery.Backoff
+	mu         sync.Mutex
+	size       int64
+	count      int
+	notify     chan bool
+	ch         chan bool
+	done       chan bool
+}
+
+type batch struct {
+	Time     int64    `json:"time"`
+	Messages []string `json:"messages"`
+}
+
+// New opens (or creates) queue file by path and starts delivery worker for transport t.
+// maxBytes and maxBatches limit queue size, zero means unlimited.
+// backoff defines how long to wait before next attempt after failed delivery.
+func New(path string, t transport.ITransportClient, maxBytes int64, maxBatches int, backoff *delivery.Backoff) (*Queue, error) {
+	q := &Queue{
+		path:       path,
+		bucketName: []byte("batches"),
+		t:          t,
+		maxBytes:   maxBytes,
+		maxBatches: maxBatches,
+		backoff:    backoff,
+		notify:     make(chan bool, 1),
+		ch:         make(chan bool),
+		done:       make(chan bool),
+	}
+	var err error
+	q.db, err = bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open queue file %s, %w", path, err)
+	}
+	err = q.recover()
+	if err != nil {
+		q.db.Close()
+		return nil, err
+	}
+	log.Printf("Delivery queue '%s' opened with %d batches (%d bytes) pending", path, q.count, q.size)
+	go q.deliveryLoop()
+	return q, nil
+}
+
+// recover creates bucket if needed and restores queue counters from disk
+func (q *Queue) recover() error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(q.bucketName)
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		return b.ForEach(func(k, v []byte) error {
+			q.size += int64(len(v))
+			q.count++
+			return nil
+		})
+	})
+}
+
+// DeliverMessages stores batch in queue, it does not wait for real delivery
+func (q *Queue) DeliverMessages(data []string) error {
+	value, err := json.Marshal(batch{Time: time.Now().UnixNano(), Messages: data})
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.maxBatches > 0 && q.count >= q.maxBatches {
+		return ErrQueueFull
+	}
+	if q.maxBytes > 0 && q.size+int64(len(value)) > q.maxBytes {
+		return ErrQueueFull
+	}
+	err = q.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(q.bucketName)
+		id, cerr := b.NextSequence()
+		if cerr != nil {
+			return cerr
+		}
+		return b.Put(itob(id), value)
+	})
+	if err != nil {
+		return err
+	}
+	q.size += int64(len(value))
+	q.count++
+	select {
+	case q.notify <- true:
+	default:
+	}
+	return nil
+}
+
+// Len returns number of batches waiting for delivery
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.count
+}
+
+// Size returns size of batches waiting for delivery in bytes
+func (q *Queue) Size() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+// HealthCheck checks health of underlying transport
+func (q *Queue) HealthCheck() error {
+	return transport.HealthCheck(q.t)
+}
+
+// Close stops delivery worker, closes queue file and underlying transport.
+// Batches which are not delivered yet stay on disk till next start.
+func (q *Queue) Close() error {
+	close(q.ch)
+	<-q.done
+	err := q.db.Close()
+	if err != nil {
+		log.Printf("Unable to close queue file '%s': %s", q.path, err)
+	}
+	return q.t.Close()
+}
+
+func (q *Queue) deliveryLoop() {
+	defer close(q.done)
+	attempt := 0
+	for {
+		id, b, size, err := q.peek()
+		if err != nil {
+			log.Printf("Unable to read batch from delivery queue '%s': %s", q.path, err)
+		}
+		q.updateMetrics(b)
+		if b == nil {
+			select {
+			case <-q.ch:
+				return
+			case <-q.notify:
+			}
+			continue
+		}
+		if len(b.Messages) != 0 {
+			err = q.t.DeliverMessages(b.Messages)
+			if err != nil {
+				attempt++
+				delay := q.backoff.Delay(attempt)
+				log.Printf("Queue: unable to send data %s, sleep for %s and try again", err, delay)
+				if !delivery.Wait(q.t, delay, q.ch) {
+					return
+				}
+				continue
+			}
+		}
+		attempt = 0
+		err = q.remove(id, size)
+		if err != nil {
+			log.Printf("Unable to remove delivered batch from queue '%s': %s", q.path, err)
+		}
+	}
+}
+
+// peek returns the oldest batch in queue or nil batch if queue is empty
+func (q *Queue) peek() (uint64, *batch, int64, error) {
+	var id uint64
+	var b *batch
+	var size int64
+	err := q.db.View(func(tx *bolt.Tx) error {
+		k, v := tx.Bucket(q.bucketName).Cursor().First()
+		if k == nil {
+			return nil
+		}
+		id = binary.BigEndian.Uint64(k)
+		size = int64(len(v))
+		b = &batch{}
+		return json.Unmarshal(v, b)
+	})
+	if err != nil && b != nil {
+		// Broken batch can't be delivered anyway, drop it and continue with next one
+		log.Printf("Drop broken batch %d from delivery queue '%s': %s", id, q.path, err)
+		b.Messages = nil
+		return id, b, size, nil
+	}
+	return id, b, size, err
+}
+
+func (q *Queue) remove(id uint64, size int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(q.bucketName).Delete(itob(id))
+	})
+	if err != nil {
+		return err
+	}
+	q.size -= size
+	q.count--
+	return nil
+}
+
+func (q *Queue) updateMetrics(oldest *batch) {
+	age := 0.0
+	if oldest != nil {
+		age = time.Since(time.Unix(0, oldest.Time)).Seconds()
+	}
+	metrics.QueueOldestBatchAge.Set(age)
+	metrics.QueueDepth.Set(float64(q.Len()))
+	metrics.QueueSize.Set(float64(q.Size()))
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
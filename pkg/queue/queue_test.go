@@ -0,0 +1,75 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"rvadim/loggo/pkg/delivery"
+	"rvadim/loggo/pkg/tests"
+)
+
+func waitFor(t *testing.T, condition func() bool) {
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not satisfied in time")
+}
+
+func TestQueueDelivery(t *testing.T) {
+	dir := t.TempDir()
+	transport := &tests.TransportMock{}
+	q, err := New(dir+"/loggo-queue.db", transport, 0, 0, delivery.NewBackoff(10*time.Millisecond, 0, 1, 0))
+	assert.NoError(t, err)
+
+	assert.NoError(t, q.DeliverMessages([]string{"a", "b"}))
+	assert.NoError(t, q.DeliverMessages([]string{"c"}))
+	waitFor(t, func() bool { return q.Len() == 0 })
+	assert.Equal(t, []string{"a", "b", "c"}, transport.GetMessages())
+	assert.Equal(t, int64(0), q.Size())
+
+	assert.NoError(t, q.Close())
+	assert.True(t, transport.GetClosed())
+}
+
+func TestQueueRecovery(t *testing.T) {
+	dir := t.TempDir()
+	transport := &tests.TransportMock{}
+	transport.SetBroken(true)
+	q, err := New(dir+"/loggo-queue.db", transport, 0, 0, delivery.NewBackoff(10*time.Millisecond, 0, 1, 0))
+	assert.NoError(t, err)
+	assert.NoError(t, q.DeliverMessages([]string{"a"}))
+	assert.NoError(t, q.DeliverMessages([]string{"b"}))
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, 2, q.Len())
+	q.Close()
+
+	transport = &tests.TransportMock{}
+	q, err = New(dir+"/loggo-queue.db", transport, 0, 0, delivery.NewBackoff(10*time.Millisecond, 0, 1, 0))
+	assert.NoError(t, err)
+	waitFor(t, func() bool { return q.Len() == 0 })
+	assert.Equal(t, []string{"a", "b"}, transport.GetMessages())
+	q.Close()
+}
+
+func TestQueueLimits(t *testing.T) {
+	dir := t.TempDir()
+	transport := &tests.TransportMock{}
+	transport.SetBroken(true)
+	q, err := New(dir+"/loggo-queue.db", transport, 0, 2, delivery.NewBackoff(time.Second, 0, 1, 0))
+	assert.NoError(t, err)
+	assert.NoError(t, q.DeliverMessages([]string{"a"}))
+	assert.NoError(t, q.DeliverMessages([]string{"b"}))
+	assert.Equal(t, ErrQueueFull, q.DeliverMessages([]string{"c"}))
+	q.Close()
+
+	q, err = New(dir+"/loggo-queue-bytes.db", transport, 100, 0, delivery.NewBackoff(time.Second, 0, 1, 0))
+	assert.NoError(t, err)
+	assert.NoError(t, q.DeliverMessages([]string{"small"}))
+	assert.Equal(t, ErrQueueFull, q.DeliverMessages([]string{string(make([]byte, 100))}))
+	assert.Equal(t, 1, q.Len())
+	q.Close()
+}
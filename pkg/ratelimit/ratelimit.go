@@ -0,0 +1,253 @@
+package ratelimit
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"rvadim/loggo/pkg/metrics"
+	"rvadim/loggo/pkg/parser"
+)
+
+// Rate limit units
+const (
+	UnitLines = "lines"
+	UnitBytes = "bytes"
+)
+
+// Rate limit actions
+const (
+	// ActionDrop drops records over limit
+	ActionDrop = "drop"
+	// ActionSample keeps every N-th record over limit
+	ActionSample = "sample"
+	// ActionDelay waits till record fits into limit, reading of container log slows down
+	ActionDelay = "delay"
+)
+
+// Actions are all rate limit actions, they are values of action label of rate limit metric
+var Actions = []string{ActionDrop, ActionSample, ActionDelay}
+
+// Labels identify container in metrics
+type Labels struct {
+	Namespace string
+	PodName   string
+	Container string
+}
+
+func (l Labels) values() []string {
+	return []string{l.Namespace, l.PodName, l.Container}
+}
+
+// Rule limits rate of records of containers selected by Scope
+type Rule struct {
+	parser.Scope
+	Rate    float64
+	Unit    string
+	Action  string
+	SampleN int
+}
+
+// ParseRule parses rule from string '<namespace-regex>:<container-regex>:<rate>:<lines|bytes>:<drop|delay|sample/N>',
+// rate is per second
+func ParseRule(rule string) (*Rule, error) {
+	parts := strings.SplitN(rule, ":", 5)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("invalid rate limit rule '%s', "+
+			"expected '<namespace-regex>:<container-regex>:<rate>:<lines|bytes>:<drop|delay|sample/N>'", rule)
+	}
+	r := &Rule{Unit: parts[3]}
+	var err error
+	if r.Scope, err = parser.NewScope(parts[0], parts[1]); err != nil {
+		return nil, fmt.Errorf("invalid rate limit rule '%s', %w", rule, err)
+	}
+	if r.Rate, err = strconv.ParseFloat(parts[2], 64); err != nil || r.Rate <= 0 {
+		return nil, fmt.Errorf("invalid rate limit rule '%s', rate must be positive number", rule)
+	}
+	if r.Unit != UnitLines && r.Unit != UnitBytes {
+		return nil, fmt.Errorf("invalid rate limit rule '%s', unknown unit '%s'", rule, r.Unit)
+	}
+	action := strings.SplitN(parts[4], "/", 2)
+	r.Action = action[0]
+	switch r.Action {
+	case ActionDrop, ActionDelay:
+		if len(action) != 1 {
+			return nil, fmt.Errorf("invalid rate limit rule '%s', action '%s' has no parameters", rule, r.Action)
+		}
+	case ActionSample:
+		if len(action) != 2 {
+			return nil, fmt.Errorf("invalid rate limit rule '%s', expected 'sample/N'", rule)
+		}
+		if r.SampleN, err = strconv.Atoi(action[1]); err != nil || r.SampleN < 1 {
+			return nil, fmt.Errorf("invalid rate limit rule '%s', N of sample must be positive integer", rule)
+		}
+	default:
+		return nil, fmt.Errorf("invalid rate limit rule '%s', unknown action '%s'", rule, r.Action)
+	}
+	return r, nil
+}
+
+// FindRule returns the first rule matched by namespace and container name or nil
+func FindRule(rules []*Rule, namespace string, container string) *Rule {
+	for _, r := range rules {
+		if r.Match(namespace, container) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Limiter is a token bucket limiting rate of records of one container,
+// bucket holds tokens for one second of rate
+type Limiter struct {
+	rule    *Rule
+	labels  []string
+	tokens  float64
+	updated time.Time
+	over    int
+	now     func() time.Time
+	wait    func(d time.Duration, stop <-chan bool) bool
+	stop    <-chan bool
+}
+
+// NewLimiter creates new Limiter with full bucket
+func NewLimiter(rule *Rule, labels Labels) *Limiter {
+	return &Limiter{
+		rule:    rule,
+		labels:  labels.values(),
+		tokens:  rule.Rate,
+		updated: time.Now(),
+		now:     time.Now,
+		wait:    wait,
+	}
+}
+
+// SetStop sets stop channel of reader, it interrupts waiting of delay action
+func (l *Limiter) SetStop(stop <-chan bool) {
+	l.stop = stop
+}
+
+// Process takes tokens for record, record over limit is dropped, sampled or delayed
+func (l *Limiter) Process(record parser.Properties) (parser.Properties, error) {
+	cost := 1.0
+	if l.rule.Unit == UnitBytes {
+		body, _ := record["log"].(string)
+		cost = float64(len(body))
+	}
+	if cost > l.rule.Rate {
+		// Record bigger than bucket passes when bucket is full
+		cost = l.rule.Rate
+	}
+	l.refill()
+	if l.tokens >= cost {
+		l.tokens -= cost
+		return record, nil
+	}
+	metrics.RateLimitedCount.WithLabelValues(append(l.labels, l.rule.Action)...).Inc()
+	switch l.rule.Action {
+	case ActionDelay:
+		// Record passes without waiting the rest of delay if reader is stopped
+		if l.wait(time.Duration((cost-l.tokens)/l.rule.Rate*float64(time.Second)), l.stop) {
+			l.refill()
+			l.tokens -= cost
+		}
+		return record, nil
+	case ActionSample:
+		l.over++
+		if l.over%l.rule.SampleN == 0 {
+			return record, nil
+		}
+	}
+	return nil, nil
+}
+
+// wait sleeps for d, returns false if stop channel closed before
+func wait(d time.Duration, stop <-chan bool) bool {
+	select {
+	case <-stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func (l *Limiter) refill() {
+	now := l.now()
+	l.tokens += now.Sub(l.updated).Seconds() * l.rule.Rate
+	if l.tokens > l.rule.Rate {
+		l.tokens = l.rule.Rate
+	}
+	l.updated = now
+}
+
+// SampleRule keeps Rate part of records of containers selected by Scope. If Field set,
+// decision made by hash of its value, so all records with the same value (for example
+// request_id) are kept or dropped together.
+type SampleRule struct {
+	parser.Scope
+	Rate  float64
+	Field string
+}
+
+// ParseSampleRule parses rule from string '<namespace-regex>:<container-regex>:<rate>[:<field>]',
+// rate is between 0 and 1
+func ParseSampleRule(rule string) (*SampleRule, error) {
+	parts := strings.SplitN(rule, ":", 4)
+	if len(parts) < 3 {
+		return nil, fmt.Errorf("invalid sample rule '%s', expected '<namespace-regex>:<container-regex>:<rate>[:<field>]'", rule)
+	}
+	r := &SampleRule{}
+	if len(parts) == 4 {
+		r.Field = parts[3]
+	}
+	var err error
+	if r.Scope, err = parser.NewScope(parts[0], parts[1]); err != nil {
+		return nil, fmt.Errorf("invalid sample rule '%s', %w", rule, err)
+	}
+	if r.Rate, err = strconv.ParseFloat(parts[2], 64); err != nil || r.Rate < 0 || r.Rate > 1 {
+		return nil, fmt.Errorf("invalid sample rule '%s', rate must be number between 0 and 1", rule)
+	}
+	return r, nil
+}
+
+// FindSampleRule returns the first rule matched by namespace and container name or nil
+func FindSampleRule(rules []*SampleRule, namespace string, container string) *SampleRule {
+	for _, r := range rules {
+		if r.Match(namespace, container) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Sampler keeps part of records by SampleRule
+type Sampler struct {
+	rule   *SampleRule
+	labels []string
+	random func() float64
+}
+
+// NewSampler creates new Sampler
+func NewSampler(rule *SampleRule, labels Labels) *Sampler {
+	return &Sampler{rule: rule, labels: labels.values(), random: rand.Float64}
+}
+
+// Process drops records which are not sampled, records without Field sampled randomly
+func (s *Sampler) Process(record parser.Properties) (parser.Properties, error) {
+	var point float64
+	if value, ok := record[s.rule.Field]; ok && s.rule.Field != "" && value != nil {
+		h := fnv.New32a()
+		h.Write([]byte(fmt.Sprint(value)))
+		point = float64(h.Sum32()) / (1 << 32)
+	} else {
+		point = s.random()
+	}
+	if point < s.rule.Rate {
+		return record, nil
+	}
+	metrics.SampledOutCount.WithLabelValues(s.labels...).Inc()
+	return nil, nil
+}
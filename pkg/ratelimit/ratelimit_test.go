@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"rvadim/loggo/pkg/parser"
+)
+
+func TestParseRule(t *testing.T) {
+	r, err := ParseRule("^noisy$::100:lines:sample/10")
+	assert.NoError(t, err)
+	assert.Equal(t, 100.0, r.Rate)
+	assert.Equal(t, UnitLines, r.Unit)
+	assert.Equal(t, ActionSample, r.Action)
+	assert.Equal(t, 10, r.SampleN)
+	assert.Equal(t, r, FindRule([]*Rule{r}, "noisy", "app"))
+	assert.Nil(t, FindRule([]*Rule{r}, "prod", "app"))
+
+	for _, rule := range []string{
+		"::100:lines", "::0:lines:drop", "::100:kb:drop", "::100:lines:block",
+		"::100:lines:sample", "::100:lines:sample/0", "::100:lines:drop/2", "(::100:lines:drop",
+	} {
+		_, err = ParseRule(rule)
+		assert.Error(t, err, rule)
+	}
+}
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func (c *clock) Wait(d time.Duration, stop <-chan bool) bool {
+	c.now = c.now.Add(d)
+	return true
+}
+
+func newTestLimiter(rule string) (*Limiter, *clock) {
+	r, _ := ParseRule(rule)
+	c := &clock{now: time.Unix(0, 0)}
+	l := NewLimiter(r, Labels{Namespace: "ns", PodName: "pod", Container: "app"})
+	l.now, l.wait, l.updated = c.Now, c.Wait, c.now
+	return l, c
+}
+
+func passed(l *Limiter, records int, body string) int {
+	n := 0
+	for i := 0; i < records; i++ {
+		if record, _ := l.Process(parser.Properties{"log": body}); record != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLimiter(t *testing.T) {
+	l, c := newTestLimiter("::10:lines:drop")
+	assert.Equal(t, 10, passed(l, 15, "a"))
+	c.now = c.now.Add(500 * time.Millisecond)
+	assert.Equal(t, 5, passed(l, 15, "a"))
+
+	l, _ = newTestLimiter("::10:lines:sample/5")
+	assert.Equal(t, 12, passed(l, 20, "a"))
+
+	l, c = newTestLimiter("::100:bytes:delay")
+	assert.Equal(t, 4, passed(l, 4, "0123456789012345678901234567890123456789012345678"))
+	// 196 bytes sent with 100 bytes in bucket, the rest waited for 0.96s
+	assert.Equal(t, time.Unix(0, 0).Add(960*time.Millisecond), c.now.Round(time.Millisecond))
+
+	// Stop of reader interrupts delay
+	r, _ := ParseRule("::1:lines:delay")
+	l = NewLimiter(r, Labels{})
+	stop := make(chan bool)
+	l.SetStop(stop)
+	assert.Equal(t, 1, passed(l, 1, "a"))
+	close(stop)
+	started := time.Now()
+	assert.Equal(t, 3, passed(l, 3, "a"))
+	assert.True(t, time.Since(started) < 500*time.Millisecond)
+
+	// Record bigger than bucket is not blocked forever
+	l, _ = newTestLimiter("::10:bytes:drop")
+	assert.Equal(t, 1, passed(l, 2, "01234567890123456789"))
+}
+
+func TestSampler(t *testing.T) {
+	_, err := ParseSampleRule("::2")
+	assert.Error(t, err)
+	_, err = ParseSampleRule("::")
+	assert.Error(t, err)
+	r, err := ParseSampleRule("::0.5:request_id")
+	assert.NoError(t, err)
+	assert.Equal(t, r, FindSampleRule([]*SampleRule{r}, "ns", "app"))
+
+	s := NewSampler(r, Labels{})
+	s.random = func() float64 { return 0.7 }
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		id := parser.Properties{"request_id": i}
+		first, _ := s.Process(id)
+		second, _ := s.Process(parser.Properties{"request_id": i})
+		assert.Equal(t, first == nil, second == nil)
+		if first != nil {
+			kept++
+		}
+	}
+	assert.InDelta(t, 500, kept, 60)
+
+	record, _ := s.Process(parser.Properties{"log": "no request id"})
+	assert.Nil(t, record)
+	s.random = func() float64 { return 0.1 }
+	record, _ = s.Process(parser.Properties{"log": "no request id"})
+	assert.NotNil(t, record)
+}
@@ -12,16 +12,43 @@ import (
 	"github.com/fsnotify/fsnotify"
 
 	"rvadim/loggo/pkg/config"
+	"rvadim/loggo/pkg/delivery"
 	"rvadim/loggo/pkg/metrics"
+	"rvadim/loggo/pkg/ratelimit"
 	"rvadim/loggo/pkg/storage"
 	"rvadim/loggo/pkg/transport"
 )
 
+// IParser parses log lines, empty output means line buffered by parser and there is nothing to send yet
 type IParser interface {
 	GetProperty(key string) interface{}
 	ParseLine(line string) (string, error)
 }
 
+// ITruncatedParser implemented by parsers which can parse the beginning of too long line
+type ITruncatedParser interface {
+	ParseTruncatedLine(line string, length int) (string, error)
+}
+
+// IFlusher implemented by parsers which buffer lines between ParseLine calls (for example multiline events)
+type IFlusher interface {
+	Flush(force bool) []string
+}
+
+// IStopParser implemented by parsers which may wait inside ParseLine (for example rate limit delay),
+// stop channel of reader interrupts waiting
+type IStopParser interface {
+	SetStop(stop <-chan bool)
+}
+
+// IOffsetParser implemented by parsers which hold lines between ParseLine calls, position
+// stored in registry is offset of the first line still held by parser, so held lines are
+// read again after restart
+type IOffsetParser interface {
+	SetOffset(offset int64)
+	PendingOffset() (int64, bool)
+}
+
 // KubernetesPodName name of field
 const KubernetesPodName = "kubernetes.pod_name"
 
@@ -34,6 +61,17 @@ const KubernetesContainerName = "kubernetes.container_name"
 // KubernetesNodeHostname name of field
 const KubernetesNodeHostname = "kubernetes.node_hostname"
 
+// Names of fields with pod metadata from Kubernetes API
+const (
+	KubernetesPodID             = "kubernetes.pod_id"
+	KubernetesPodIP             = "kubernetes.pod_ip"
+	KubernetesContainerImage    = "kubernetes.container_image"
+	KubernetesOwnerKind         = "kubernetes.owner_kind"
+	KubernetesOwnerName         = "kubernetes.owner_name"
+	KubernetesLabelsPrefix      = "kubernetes.labels."
+	KubernetesAnnotationsPrefix = "kubernetes.annotations."
+)
+
 // Reader common struct for log reader
 type Reader struct {
 	registry      *storage.RegistryFile
@@ -42,11 +80,15 @@ type Reader struct {
 	file          *os.File
 	pos           int64
 	maxChunk      int
+	maxLineBytes  int
+	dropOversized bool
 	ReaderTimeout time.Duration
+	eof           bool
 	ch            chan bool
 	waitGroup     *sync.WaitGroup
 	watcher       *fsnotify.Watcher
 	parser        IParser
+	backoff       *delivery.Backoff
 }
 
 // InitReader initialize registry and transport
@@ -58,9 +100,15 @@ func InitReader(path string, t transport.ITransportClient,
 		registry:      registry,
 		filePath:      path,
 		maxChunk:      c.ReaderMaxChunk,
+		maxLineBytes:  c.ReaderMaxLineBytes,
+		dropOversized: c.ReaderOversizedAction == "drop",
 		ReaderTimeout: time.Duration(c.ReaderTimeoutSec) * time.Second,
 		parser:        p,
 		t:             t,
+		backoff:       delivery.NewBackoffFromConfig(c),
+	}
+	if sp, ok := p.(IStopParser); ok {
+		sp.SetStop(ch)
 	}
 	r.pos = r.getPosition()
 	var err error
@@ -100,6 +148,15 @@ func (r *Reader) setPosition(pos int64) {
 	}
 }
 
+// deleteMetrics deletes metrics series of container, so series of removed containers do not pile up
+func deleteMetrics(namespace string, podName string, containerName string) {
+	metrics.LogMessageCount.DeleteLabelValues(namespace, podName, containerName)
+	metrics.SampledOutCount.DeleteLabelValues(namespace, podName, containerName)
+	for _, action := range ratelimit.Actions {
+		metrics.RateLimitedCount.DeleteLabelValues(namespace, podName, containerName, action)
+	}
+}
+
 // ProcessLogFile process log file
 func (r *Reader) ProcessLogFile() {
 	defer r.waitGroup.Done()
@@ -123,7 +180,7 @@ func (r *Reader) ProcessLogFile() {
 		_, err := os.Stat(r.filePath)
 		if err != nil && !lastIteration {
 			log.Printf("File not present on fs, remove '%s' from registry", r.filePath)
-			metrics.LogMessageCount.DeleteLabelValues(namespace, podName, containerName)
+			deleteMetrics(namespace, podName, containerName)
 			r.registry.Delete(r.filePath)
 			return
 		}
@@ -132,26 +189,20 @@ func (r *Reader) ProcessLogFile() {
 			log.Printf("Important: Unable to read file %s, from position %d, %s", r.file.Name(), r.pos, err)
 			continue
 		}
-		if len(data) != 0 {
-			err = r.t.DeliverMessages(data)
-			if err != nil {
-				log.Printf("%s: Unable to send data %s, sleep for %s and start processing again", r.filePath, err, r.ReaderTimeout)
-				time.Sleep(r.ReaderTimeout)
-				select {
-				case <-r.ch:
-					log.Printf("Stop reading '%s' due to channel closed", r.filePath)
-					return
-				default:
-				}
-				continue
-			}
+		if len(data) != 0 && !r.deliver(data) {
+			log.Printf("Stop reading '%s' due to channel closed", r.filePath)
+			return
 		}
 		metrics.LogMessageCount.WithLabelValues(namespace, podName, containerName).Add(float64(len(data)))
-		r.setPosition(pos)
+		r.setPosition(r.committedPosition(pos))
 		r.pos = pos
-		if len(data) < r.maxChunk {
+		if r.eof {
 			if lastIteration {
-				metrics.LogMessageCount.DeleteLabelValues(namespace, podName, containerName)
+				if !r.flush() {
+					log.Printf("Stop reading '%s', buffered records are not delivered", r.filePath)
+					return
+				}
+				deleteMetrics(namespace, podName, containerName)
 				r.registry.Delete(r.filePath)
 				return
 			}
@@ -161,6 +212,11 @@ func (r *Reader) ProcessLogFile() {
 		select {
 		case <-r.ch:
 			log.Println("Stop reading", r.filePath)
+			if r.flush() {
+				r.setPosition(r.pos)
+			} else {
+				log.Printf("%s: buffered records are not delivered, they will be read again after restart", r.filePath)
+			}
 			return
 		case event := <-r.watcher.Events:
 			if event.Op == fsnotify.Rename {
@@ -168,7 +224,7 @@ func (r *Reader) ProcessLogFile() {
 			}
 			if event.Op == fsnotify.Remove {
 				// FIXME Never executed due to https://github.com/fsnotify/fsnotify/issues/194
-				metrics.LogMessageCount.DeleteLabelValues(namespace, podName, containerName)
+				deleteMetrics(namespace, podName, containerName)
 				r.registry.Delete(r.filePath)
 				return
 			}
@@ -179,7 +235,49 @@ func (r *Reader) ProcessLogFile() {
 	}
 }
 
-// ReadDataReadBytes read from start position and return max lines from file
+// deliver sends data to transport, failed delivery retried with backoff,
+// while transport circuit breaker is open reader parks.
+// Returns false if reader stopped before data delivered.
+func (r *Reader) deliver(data []string) bool {
+	for attempt := 1; ; attempt++ {
+		err := r.t.DeliverMessages(data)
+		if err == nil {
+			return true
+		}
+		delay := r.backoff.Delay(attempt)
+		log.Printf("%s: Unable to send data %s, sleep for %s and try again", r.filePath, err, delay)
+		if !delivery.Wait(r.t, delay, r.ch) {
+			return false
+		}
+	}
+}
+
+// flush sends all records buffered by parser, returns false if they are not delivered
+func (r *Reader) flush() bool {
+	f, ok := r.parser.(IFlusher)
+	if !ok {
+		return true
+	}
+	data := f.Flush(true)
+	if len(data) == 0 {
+		return true
+	}
+	return r.deliver(data)
+}
+
+// committedPosition returns position stored in registry after lines are read till pos,
+// it is offset of the first line held by parser or pos if parser holds nothing
+func (r *Reader) committedPosition(pos int64) int64 {
+	if p, ok := r.parser.(IOffsetParser); ok {
+		if offset, ok := p.PendingOffset(); ok && offset < pos {
+			return offset
+		}
+	}
+	return pos
+}
+
+// ReadDataReadBytes read from start position and return records of max lines from file,
+// parser may buffer or drop lines, so there may be less records than lines
 func (r *Reader) ReadDataReadBytes(input io.ReadSeeker, start int64, max int) (int64, []string, error) {
 	if _, err := input.Seek(start, 0); err != nil {
 		return 0, nil, err
@@ -187,21 +285,77 @@ func (r *Reader) ReadDataReadBytes(input io.ReadSeeker, start int64, max int) (i
 	var buffer []string
 	reader := bufio.NewReader(input)
 	pos := start
+	r.eof = false
+	op, _ := r.parser.(IOffsetParser)
 	for i := 0; i <= max; i++ {
-		data, err := reader.ReadBytes('\n')
-		if len(data) == 0 && err == io.EOF {
+		data, length, err := r.readLine(reader)
+		if length == 0 && err == io.EOF {
+			r.eof = true
 			break
 		}
-		pos += int64(len(data))
+		if op != nil {
+			op.SetOffset(pos)
+		}
+		pos += int64(length)
 		if err == nil || err == io.EOF {
-			out, _ := r.parser.ParseLine(string(data))
-			buffer = append(buffer, out)
-		} else if err != nil {
+			if out := r.parseLine(data, length); out != "" {
+				buffer = append(buffer, out)
+			}
+		}
+		if err != nil {
 			if err != io.EOF {
 				return 0, nil, err
 			}
+			r.eof = true
 			break
 		}
 	}
+	if f, ok := r.parser.(IFlusher); ok && r.eof {
+		// End of file reached, send events which are not updated during timeout
+		buffer = append(buffer, f.Flush(false)...)
+	}
 	return pos, buffer, nil
 }
+
+// readLine reads line keeping in memory no more than maxLineBytes of it,
+// returns kept part of line and length of the whole line
+func (r *Reader) readLine(reader *bufio.Reader) ([]byte, int, error) {
+	var line []byte
+	length := 0
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		length += len(chunk)
+		if r.maxLineBytes <= 0 || len(line) < r.maxLineBytes {
+			if r.maxLineBytes > 0 && len(line)+len(chunk) > r.maxLineBytes {
+				chunk = chunk[:r.maxLineBytes-len(line)]
+			}
+			// Chunk is a part of reader buffer, so it is copied
+			line = append(line, chunk...)
+		}
+		if err != bufio.ErrBufferFull {
+			return line, length, err
+		}
+	}
+}
+
+// parseLine parses line, line longer than maxLineBytes is truncated or dropped
+func (r *Reader) parseLine(data []byte, length int) string {
+	if r.maxLineBytes <= 0 || length <= r.maxLineBytes {
+		out, _ := r.parser.ParseLine(string(data))
+		return out
+	}
+	if r.dropOversized {
+		log.Printf("%s: Drop line of %d bytes, it is longer than %d bytes", r.filePath, length, r.maxLineBytes)
+		return ""
+	}
+	p, ok := r.parser.(ITruncatedParser)
+	if !ok {
+		log.Printf("%s: Drop line of %d bytes, parser does not support truncated lines", r.filePath, length)
+		return ""
+	}
+	out, err := p.ParseTruncatedLine(string(data), length)
+	if err != nil && out == "" {
+		log.Printf("%s: Drop line of %d bytes, unable to parse truncated line, %s", r.filePath, length, err)
+	}
+	return out
+}
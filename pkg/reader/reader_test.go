@@ -3,10 +3,13 @@ package reader
 import (
 	"log"
 	"os"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
 
+	"rvadim/loggo/pkg/metrics"
+	"rvadim/loggo/pkg/multiline"
 	"rvadim/loggo/pkg/parser"
 	"rvadim/loggo/pkg/storage"
 	"rvadim/loggo/pkg/tests"
@@ -189,3 +192,241 @@ func TestTransportFaliure(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "", position)
 }
+
+func TestReaderMultiline(t *testing.T) {
+	dir := t.TempDir()
+	createTestFile(dir+"/loggo-test-multiline.log", `{"log":"Traceback (most recent call last):\n"}
+{"log":"  File \"main.py\", line 1, in main\n"}
+{"log":"ZeroDivisionError: division by zero\n"}
+{"log":"{\"a\": 1}\n"}
+`)
+
+	registry, _ := storage.NewRegistryFile(dir+"/test-multiline.db", 1)
+	defer registry.Close()
+
+	rule, _ := multiline.ParseRule(`.*:continue:^(\s|\w+Error)`)
+	processors := append([]parser.Processor{multiline.NewJoiner(rule, 0, 0, time.Hour)}, parser.DefaultProcessors()...)
+	p := parser.NewPipeline(parser.NewDockerDecoder(0, 0), processors, parser.Properties{})
+	r := InitReader(dir+"/loggo-test-multiline.log", &tests.RedisClientMock{}, registry, make(chan bool),
+		&sync.WaitGroup{}, p, &config.Config{ReaderMaxChunk: 10})
+	file, _ := os.Open(dir + "/loggo-test-multiline.log")
+	defer file.Close()
+	_, data, err := r.ReadDataReadBytes(file, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		`{"log":"Traceback (most recent call last):\n  File \"main.py\", line 1, in main\nZeroDivisionError: division by zero\n"}`,
+	}, data)
+	assert.Equal(t, []string{`{"a":1}`}, p.Flush(true))
+}
+
+func TestReaderFiltered(t *testing.T) {
+	dir := t.TempDir()
+	content := `{"log":"GET /healthz\n"}
+{"log":"GET /healthz\n"}
+{"log":"GET /\n"}
+`
+	createTestFile(dir+"/loggo-test-filter.log", content)
+
+	transport := &tests.RedisClientMock{}
+	transport.Connect("127.0.0.1", "32770", "my-logs")
+
+	registry, _ := storage.NewRegistryFile(dir+"/test-filter.db", 1)
+	defer registry.Close()
+	ch := make(chan bool)
+	wg := &sync.WaitGroup{}
+	rule, _ := parser.ParseFilterRule("::drop:=healthz")
+	p := parser.NewPipeline(parser.NewDockerDecoder(0, 0), []parser.Processor{parser.NewFilterProcessor([]*parser.FilterRule{rule})}, parser.Properties{})
+	r := InitReader(dir+"/loggo-test-filter.log", transport, registry, ch, wg, p, &config.Config{ReaderMaxChunk: 10})
+	r.ReaderTimeout = 1000
+	go r.ProcessLogFile()
+	close(ch)
+	wg.Wait()
+	assert.Equal(t, []string{`{"log":"GET /\n"}`}, transport.GetBuffer())
+	pos, _ := registry.Get(dir + "/loggo-test-filter.log")
+	assert.Equal(t, strconv.Itoa(len(content)), pos)
+}
+
+func TestReaderOversizedLines(t *testing.T) {
+	dir := t.TempDir()
+	long := ""
+	for i := 0; i < 10000; i++ {
+		long += "0123456789"
+	}
+	content := `{"log":"short\n"}
+{"log":"` + long + `\n","stream":"stdout"}
+{"log":"end\n"}
+`
+	createTestFile(dir+"/loggo-test-oversized.log", content)
+
+	for _, action := range []string{"truncate", "drop"} {
+		transport := &tests.RedisClientMock{}
+		transport.Connect("127.0.0.1", "32770", "my-logs")
+		registry, _ := storage.NewRegistryFile(dir+"/test-oversized-"+action+".db", 1)
+		ch := make(chan bool)
+		wg := &sync.WaitGroup{}
+		p := parser.New(parser.Properties{})
+		r := InitReader(dir+"/loggo-test-oversized.log", transport, registry, ch, wg, p,
+			&config.Config{ReaderMaxChunk: 10, ReaderMaxLineBytes: 30, ReaderOversizedAction: action})
+		r.ReaderTimeout = 1000
+		go r.ProcessLogFile()
+		close(ch)
+		wg.Wait()
+		pos, _ := registry.Get(dir + "/loggo-test-oversized.log")
+		assert.Equal(t, strconv.Itoa(len(content)), pos)
+		registry.Close()
+
+		buffer := transport.GetBuffer()
+		if action == "drop" {
+			assert.Equal(t, []string{`{"log":"short\n"}`, `{"log":"end\n"}`}, buffer)
+			continue
+		}
+		assert.Equal(t, []string{
+			`{"log":"short\n"}`,
+			`{"_original_length":100031,"_truncated":true,"log":"0123456789012345678901"}`,
+			`{"log":"end\n"}`,
+		}, buffer)
+	}
+}
+
+func TestReaderOversizedPartialLines(t *testing.T) {
+	// Docker splits long line into partial entries, so reader limit is not reached
+	content := `{"log":"short\n"}
+{"log":"0123456789","stream":"stdout"}
+{"log":"0123456789","stream":"stdout"}
+{"log":"0123456789\n","stream":"stdout"}
+{"log":"end\n"}
+`
+	dir := t.TempDir()
+	path := dir + "/oversized-partial.log"
+	createTestFile(path, content)
+
+	for _, action := range []string{"truncate", "drop"} {
+		transport := &tests.RedisClientMock{}
+		transport.Connect("127.0.0.1", "32770", "my-logs")
+		registry, _ := storage.NewRegistryFile(dir+"/"+action+".db", 1)
+		ch := make(chan bool)
+		wg := &sync.WaitGroup{}
+		d := parser.NewDockerDecoder(15, time.Minute)
+		d.SetDropOversized(action == "drop")
+		p := parser.NewPipeline(d, []parser.Processor{}, parser.Properties{})
+		r := InitReader(path, transport, registry, ch, wg, p,
+			&config.Config{ReaderMaxChunk: 10, ReaderMaxLineBytes: 100, ReaderOversizedAction: action})
+		r.ReaderTimeout = 1000
+		go r.ProcessLogFile()
+		close(ch)
+		wg.Wait()
+		pos, _ := registry.Get(path)
+		assert.Equal(t, strconv.Itoa(len(content)), pos)
+		registry.Close()
+
+		buffer := transport.GetBuffer()
+		if action == "drop" {
+			assert.Equal(t, []string{`{"log":"short\n"}`, `{"log":"end\n"}`}, buffer)
+			continue
+		}
+		assert.Equal(t, []string{
+			`{"log":"short\n"}`,
+			`{"_original_length":31,"_truncated":true,"log":"012345678901234","stream":"stdout"}`,
+			`{"log":"end\n"}`,
+		}, buffer)
+	}
+}
+
+func TestReaderHeldLinesPosition(t *testing.T) {
+	dir := t.TempDir()
+	first := `{"log":"start\n"}
+`
+	content := first + `{"log":"Traceback (most recent call last):\n"}
+{"log":"  File \"main.py\", line 1, in main\n"}
+`
+	createTestFile(dir+"/loggo-test-held.log", content)
+	rule, _ := multiline.ParseRule(`.*:continue:^\s`)
+
+	for _, broken := range []bool{false, true} {
+		transport := &tests.TransportMock{}
+		transport.SetBroken(broken)
+		registry, _ := storage.NewRegistryFile(dir+"/test-held.db", 1)
+		ch := make(chan bool)
+		wg := &sync.WaitGroup{}
+		p := parser.NewPipeline(parser.NewDockerDecoder(0, 0),
+			[]parser.Processor{multiline.NewJoiner(rule, 0, 0, time.Hour)}, parser.Properties{})
+		r := InitReader(dir+"/loggo-test-held.log", transport, registry, ch, wg, p, &config.Config{ReaderMaxChunk: 10})
+
+		file, _ := os.Open(dir + "/loggo-test-held.log")
+		pos, data, err := r.ReadDataReadBytes(file, 0, 10)
+		file.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(content)), pos)
+		assert.Equal(t, []string{`{"log":"start\n"}`}, data)
+		// Traceback is held by joiner, so position is the beginning of it
+		assert.Equal(t, int64(len(first)), r.committedPosition(pos))
+
+		r.pos = pos
+		r.setPosition(r.committedPosition(pos))
+		r.ReaderTimeout = 1000
+		go r.ProcessLogFile()
+		close(ch)
+		wg.Wait()
+		position, _ := registry.Get(dir + "/loggo-test-held.log")
+		if broken {
+			// Held event is not delivered on stop, it is read again after restart
+			assert.Equal(t, strconv.Itoa(len(first)), position)
+		} else {
+			assert.Equal(t, strconv.Itoa(len(content)), position)
+		}
+		registry.Close()
+	}
+}
+
+func TestReaderLinesLimit(t *testing.T) {
+	dir := t.TempDir()
+	line := `{"log":"GET /healthz\n"}
+`
+	content := ""
+	for i := 0; i < 10; i++ {
+		content += line
+	}
+	createTestFile(dir+"/loggo-test-limit.log", content)
+	registry, _ := storage.NewRegistryFile(dir+"/test-limit.db", 1)
+	defer registry.Close()
+
+	rule, _ := parser.ParseFilterRule("::drop:=healthz")
+	p := parser.NewPipeline(parser.NewDockerDecoder(0, 0), []parser.Processor{parser.NewFilterProcessor([]*parser.FilterRule{rule})}, parser.Properties{})
+	r := InitReader(dir+"/loggo-test-limit.log", &tests.TransportMock{}, registry, make(chan bool), &sync.WaitGroup{}, p,
+		&config.Config{ReaderMaxChunk: 3})
+	file, _ := os.Open(dir + "/loggo-test-limit.log")
+	defer file.Close()
+	// Dropped lines are counted too, so reading stops before the end of file
+	pos, data, err := r.ReadDataReadBytes(file, 0, 3)
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+	assert.Equal(t, int64(4*len(line)), pos)
+	assert.False(t, r.eof)
+	pos, _, _ = r.ReadDataReadBytes(file, pos, 10)
+	assert.Equal(t, int64(len(content)), pos)
+	assert.True(t, r.eof)
+}
+
+func TestReaderDeletesMetrics(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/removed.log"
+	createTestFile(path, `{"log":"a\n"}
+`)
+	registry, _ := storage.NewRegistryFile(dir+"/test.db", 1)
+	defer registry.Close()
+	transport := &tests.RedisClientMock{}
+	transport.Connect("127.0.0.1", "32770", "my-logs")
+	p := parser.New(parser.Properties{KubernetesNamespaceName: "ns", KubernetesPodName: "removed-pod", KubernetesContainerName: "app"})
+	r := InitReader(path, transport, registry, make(chan bool), &sync.WaitGroup{}, p, &config.Config{ReaderMaxChunk: 10})
+	metrics.SampledOutCount.WithLabelValues("ns", "removed-pod", "app").Inc()
+	metrics.RateLimitedCount.WithLabelValues("ns", "removed-pod", "app", "drop").Inc()
+	metrics.RateLimitedCount.WithLabelValues("ns", "removed-pod", "app", "delay").Inc()
+
+	// Reader exits when file is removed, series of container are deleted
+	deleteFile(path)
+	r.ProcessLogFile()
+	assert.False(t, metrics.LogMessageCount.DeleteLabelValues("ns", "removed-pod", "app"))
+	assert.False(t, metrics.SampledOutCount.DeleteLabelValues("ns", "removed-pod", "app"))
+	assert.False(t, metrics.RateLimitedCount.DeleteLabelValues("ns", "removed-pod", "app", "drop"))
+	assert.False(t, metrics.RateLimitedCount.DeleteLabelValues("ns", "removed-pod", "app", "delay"))
+}
@@ -3,4 +3,5 @@ package service
 type IParser interface {
 	GetProperty(key string) interface{}
 	ParseLine(line string) (string, error)
+	Flush(force bool) []string
 }
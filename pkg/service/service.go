@@ -1,15 +1,22 @@
 package service
 
 import (
+	"fmt"
 	"log"
+	"regexp"
 	"rvadim/loggo/pkg/metrics"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"rvadim/loggo/pkg/config"
 	"rvadim/loggo/pkg/containerd"
 	"rvadim/loggo/pkg/docker"
+	"rvadim/loggo/pkg/k8s"
+	"rvadim/loggo/pkg/multiline"
 	"rvadim/loggo/pkg/parser"
+	"rvadim/loggo/pkg/ratelimit"
 	"rvadim/loggo/pkg/reader"
 	"rvadim/loggo/pkg/storage"
 	"rvadim/loggo/pkg/transport"
@@ -19,6 +26,11 @@ type LogsFinder interface {
 	GetAllContainers() ([]*docker.Container, error)
 }
 
+// PodsFinder finds pod metadata of container
+type PodsFinder interface {
+	Find(uid string, namespace string, name string) *k8s.Pod
+}
+
 // Options store options for service creation
 type Options struct {
 	Registry          *storage.RegistryFile
@@ -34,6 +46,7 @@ type Service struct {
 	registry  *storage.RegistryFile
 	transport transport.ITransportClient
 	finder    LogsFinder
+	pods      PodsFinder
 }
 
 // NewService Make a new Service.
@@ -50,6 +63,11 @@ func NewService(c *config.Config, r *storage.RegistryFile, t transport.ITranspor
 	return s
 }
 
+// SetPods sets finder of pod metadata added to log messages
+func (s *Service) SetPods(pods PodsFinder) {
+	s.pods = pods
+}
+
 // Stop the service by closing the service's channel.  Block until the service
 // is really stopped.
 func (s *Service) Stop() {
@@ -89,11 +107,10 @@ func (s *Service) Start() {
 					log.Printf("Error: unable to get extends for path %s, %s", container.LogPath, err)
 					continue
 				}
-				var p IParser
-				if container.CRIType == docker.CRI_TYPE_DOCKER {
-					p = parser.New(extends)
-				} else if container.CRIType == docker.CRI_TYPE_CONTAINERD {
-					p = containerd.New(extends)
+				p, err := s.newParser(container, extends)
+				if err != nil {
+					log.Printf("Error: unable to create parser for %s, %s", container.LogPath, err)
+					continue
 				}
 				log.Printf("Try to init reader for %s, cri-type: %d", container.LogPath, container.CRIType)
 				r := reader.InitReader(container.LogPath, s.transport, s.registry, s.ch, s.waitGroup, p, s.cfg)
@@ -113,10 +130,13 @@ func (s *Service) registryKeyWatcher() {
 	log.Println("Starting registry key watcher")
 	defer s.waitGroup.Done()
 	for {
-		containers, _ := s.finder.GetAllContainers()
-		keys, err := s.registry.GetAllKeys()
+		containers, err := s.finder.GetAllContainers()
+		keys, registryErr := s.registry.GetAllKeys()
 		if err != nil {
-			log.Println("Unable to read registry", err)
+			// Containers are unknown, so registry is not cleaned up to keep positions
+			log.Printf("Unable to get containers list due to %q", err)
+		} else if registryErr != nil {
+			log.Println("Unable to read registry", registryErr)
 		} else {
 			for _, key := range keys {
 				if !stringInContainerSlice(key, containers) {
@@ -155,11 +175,231 @@ func (s *Service) getExtendsForLogs(c *docker.Container) (parser.Properties, err
 	out["dc"] = s.cfg.DataCenter
 	out["purpose"] = s.cfg.Purpose
 	out["type"] = s.cfg.LogType
-	out["logstash_prefix"] = s.cfg.LogstashPrefix
+	s.setPodMetadata(s.findPod(c), c, out)
 
 	return out, nil
 }
 
+// setPodMetadata replaces pod metadata and route of container in properties
+func (s *Service) setPodMetadata(pod *k8s.Pod, c *docker.Container, out parser.Properties) {
+	for key := range out {
+		if strings.HasPrefix(key, reader.KubernetesLabelsPrefix) || strings.HasPrefix(key, reader.KubernetesAnnotationsPrefix) {
+			delete(out, key)
+		}
+	}
+	for _, key := range []string{reader.KubernetesPodID, reader.KubernetesPodIP, reader.KubernetesContainerImage,
+		reader.KubernetesOwnerKind, reader.KubernetesOwnerName} {
+		delete(out, key)
+	}
+	out["logstash_prefix"] = s.cfg.LogstashPrefix
+	if route, ok := pod.Annotation(k8s.AnnotationRoute, c.GetName()); ok && route != "" {
+		out["logstash_prefix"] = route
+	}
+	addPodMetadata(pod, c, out, s.cfg.K8sLabels, s.cfg.K8sAnnotations)
+}
+
+// podProcessor updates pod metadata in parser properties and drops records of excluded container
+// when pod is changed or cached after reader is spawned, pod is looked up by uid for every record
+type podProcessor struct {
+	s          *Service
+	c          *docker.Container
+	pod        *k8s.Pod
+	excluded   bool
+	properties parser.Properties
+}
+
+// SetProperties sets parser properties updated by processor
+func (p *podProcessor) SetProperties(properties parser.Properties) {
+	p.properties = properties
+}
+
+// Process updates properties if pod is changed, record is dropped if container is excluded by annotation
+func (p *podProcessor) Process(record parser.Properties) (parser.Properties, error) {
+	// Cache replaces pod on every change, so changed pod is a new object
+	if pod := p.s.findPod(p.c); pod != p.pod && p.properties != nil {
+		p.pod = pod
+		p.excluded = isPodExcluded(pod, p.c)
+		p.s.setPodMetadata(pod, p.c, p.properties)
+	}
+	if p.excluded {
+		return nil, nil
+	}
+	return record, nil
+}
+
+// isPodExcluded checks exclude annotation of container pod
+func isPodExcluded(pod *k8s.Pod, c *docker.Container) bool {
+	value, ok := pod.Annotation(k8s.AnnotationExclude, c.GetName())
+	if !ok {
+		return false
+	}
+	excluded, _ := strconv.ParseBool(value)
+	return excluded
+}
+
+// findPod returns pod of container or nil if pods finder is not set or pod is unknown
+func (s *Service) findPod(c *docker.Container) *k8s.Pod {
+	if s.pods == nil {
+		return nil
+	}
+	return s.pods.Find(c.GetPodUID(), c.GetPodNamespace(), c.GetPodName())
+}
+
+// addPodMetadata adds metadata of container pod if pod is known,
+// dots in label and annotation keys are replaced by underscores
+func addPodMetadata(pod *k8s.Pod, c *docker.Container, out parser.Properties, labels []string, annotations []string) {
+	if pod == nil {
+		return
+	}
+	out[reader.KubernetesPodID] = pod.UID
+	if pod.PodIP != "" {
+		out[reader.KubernetesPodIP] = pod.PodIP
+	}
+	if image, ok := pod.Images[c.GetName()]; ok {
+		out[reader.KubernetesContainerImage] = image
+	}
+	if pod.OwnerKind != "" {
+		out[reader.KubernetesOwnerKind] = pod.OwnerKind
+		out[reader.KubernetesOwnerName] = pod.OwnerName
+	}
+	for key, value := range pod.Labels {
+		if k8s.MatchKey(labels, key) {
+			out[reader.KubernetesLabelsPrefix+strings.ReplaceAll(key, ".", "_")] = value
+		}
+	}
+	for key, value := range pod.Annotations {
+		if k8s.MatchKey(annotations, key) {
+			out[reader.KubernetesAnnotationsPrefix+strings.ReplaceAll(key, ".", "_")] = value
+		}
+	}
+}
+
+// newParser creates parser pipeline for container: decoder of container runtime,
+// multiline joiner, filters, rate limit, grok, dedup and sampling (if configured for container)
+// and configured processors
+func (s *Service) newParser(c *docker.Container, extends parser.Properties) (IParser, error) {
+	// Lines split by runtime into partial entries are limited like lines read whole
+	maxBytes := s.cfg.PartialMaxBytes
+	if s.cfg.ReaderMaxLineBytes > 0 && maxBytes > s.cfg.ReaderMaxLineBytes {
+		maxBytes = s.cfg.ReaderMaxLineBytes
+	}
+	timeout := time.Duration(s.cfg.PartialTimeoutSec) * time.Second
+	drop := s.cfg.ReaderOversizedAction == "drop"
+	var d parser.Decoder
+	switch c.CRIType {
+	case docker.CRI_TYPE_DOCKER:
+		dd := parser.NewDockerDecoder(maxBytes, timeout)
+		dd.SetDropOversized(drop)
+		d = dd
+	case docker.CRI_TYPE_CONTAINERD, docker.CRI_TYPE_CRIO:
+		cd := containerd.NewDecoder(maxBytes, timeout)
+		cd.SetDropOversized(drop)
+		d = cd
+	default:
+		return nil, fmt.Errorf("unknown cri-type %d", c.CRIType)
+	}
+	pod := s.findPod(c)
+	var processors []parser.Processor
+	if s.pods != nil {
+		processors = append(processors, &podProcessor{s: s, c: c, pod: pod, excluded: isPodExcluded(pod, c)})
+	}
+	if j := s.getJoiner(c, pod); j != nil {
+		processors = append(processors, j)
+	}
+	raw, parsed := parser.FindFilterRules(s.cfg.FilterRules, c.GetPodNamespace(), c.GetName())
+	if len(raw) > 0 {
+		processors = append(processors, parser.NewFilterProcessor(raw))
+	}
+	labels := ratelimit.Labels{Namespace: c.GetPodNamespace(), PodName: c.GetPodName(), Container: c.GetName()}
+	if rule := ratelimit.FindRule(s.cfg.RateLimitRules, labels.Namespace, labels.Container); rule != nil {
+		processors = append(processors, ratelimit.NewLimiter(rule, labels))
+	}
+	if patterns := parser.FindGrokPatterns(s.cfg.GrokRules, c.GetPodNamespace(), c.GetName()); len(patterns) > 0 {
+		g, err := parser.NewGrokProcessor(patterns, s.cfg.GrokLibrary)
+		if err != nil {
+			return nil, err
+		}
+		processors = append(processors, g)
+	}
+	o := s.cfg.ParserOptions
+	o.BodyFormat = parser.FindFormat(s.cfg.BodyFormatRules, c.GetName(), o.BodyFormat)
+	if format, ok := pod.Annotation(k8s.AnnotationParser, c.GetName()); ok {
+		if _, err := parser.NewBodyProcessor(format); err != nil {
+			log.Printf("Error: invalid annotation %s of pod %s/%s, %s", k8s.AnnotationParser, pod.Namespace, pod.Name, err)
+		} else {
+			o.BodyFormat = format
+		}
+	}
+	configured, err := parser.NewProcessors(&o)
+	if err != nil {
+		return nil, err
+	}
+	processors = append(processors, configured...)
+	if len(parsed) > 0 {
+		processors = append(processors, parser.NewFilterProcessor(parsed))
+	}
+	if rule := parser.FindDedupRule(s.cfg.DedupRules, labels.Namespace, labels.Container); rule != nil && s.cfg.DedupWindowSec > 0 {
+		d, err := parser.NewDedupProcessor(parser.DedupOptions{
+			Key:      s.cfg.DedupKey,
+			Window:   time.Duration(s.cfg.DedupWindowSec) * time.Second,
+			MaxBytes: s.cfg.DedupMaxBytes,
+		})
+		if err != nil {
+			return nil, err
+		}
+		processors = append(processors, d)
+	}
+	if rule := s.getSampleRule(c, pod); rule != nil {
+		processors = append(processors, ratelimit.NewSampler(rule, labels))
+	}
+	schema, err := parser.NewSchema(s.cfg.OutputSchema)
+	if err != nil {
+		return nil, err
+	}
+	p := parser.NewPipeline(d, processors, extends)
+	p.SetSchema(schema)
+	return p, nil
+}
+
+// getSampleRule returns sample rule for container from pod annotation or config, nil if container is not sampled
+func (s *Service) getSampleRule(c *docker.Container, pod *k8s.Pod) *ratelimit.SampleRule {
+	rule := ratelimit.FindSampleRule(s.cfg.SampleRules, c.GetPodNamespace(), c.GetName())
+	value, ok := pod.Annotation(k8s.AnnotationSampleRate, c.GetName())
+	if !ok {
+		return rule
+	}
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		log.Printf("Error: invalid annotation %s of pod %s/%s, rate must be number between 0 and 1",
+			k8s.AnnotationSampleRate, pod.Namespace, pod.Name)
+		return rule
+	}
+	annotated := &ratelimit.SampleRule{Rate: rate}
+	if rule != nil {
+		annotated.Field = rule.Field
+	}
+	return annotated
+}
+
+// getJoiner returns multiline joiner for container or nil if no multiline rule matched,
+// multiline pattern annotation of pod takes precedence over rules
+func (s *Service) getJoiner(c *docker.Container, pod *k8s.Pod) *multiline.Joiner {
+	rule := multiline.FindRule(s.cfg.MultilineRules, c.GetName())
+	if value, ok := pod.Annotation(k8s.AnnotationMultilinePattern, c.GetName()); ok {
+		pattern, err := regexp.Compile(value)
+		if err != nil {
+			log.Printf("Error: invalid annotation %s of pod %s/%s, %s", k8s.AnnotationMultilinePattern, pod.Namespace, pod.Name, err)
+		} else {
+			rule = &multiline.Rule{Mode: multiline.ModeStart, Pattern: pattern}
+		}
+	}
+	if rule == nil {
+		return nil
+	}
+	return multiline.NewJoiner(rule, s.cfg.MultilineMaxLines, s.cfg.MultilineMaxBytes,
+		time.Duration(s.cfg.MultilineTimeoutSec)*time.Second)
+}
+
 func (s *Service) isNeedToSpawnProcess(c *docker.Container, isFirstIteration bool) bool {
 	if s.cfg.IncludeRegex != nil && !s.isIncluded(c.GetName()) {
 		return false
@@ -167,6 +407,9 @@ func (s *Service) isNeedToSpawnProcess(c *docker.Container, isFirstIteration boo
 	if s.cfg.ExcludeRegex != nil && s.isExcluded(c.GetName()) {
 		return false
 	}
+	if isPodExcluded(s.findPod(c), c) {
+		return false
+	}
 	position, err := s.registry.Get(c.LogPath)
 	if err != nil {
 		position = ""
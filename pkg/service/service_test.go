@@ -13,6 +13,8 @@ import (
 	"rvadim/loggo/pkg/config"
 	"rvadim/loggo/pkg/docker"
 	"rvadim/loggo/pkg/k8s"
+	"rvadim/loggo/pkg/multiline"
+	"rvadim/loggo/pkg/parser"
 	"rvadim/loggo/pkg/reader"
 	"rvadim/loggo/pkg/storage"
 	"rvadim/loggo/pkg/tests"
@@ -132,3 +134,249 @@ func TestGetExtends(t *testing.T) {
 	assert.Equal(t, "log type", p["type"])
 	assert.Equal(t, "logstash prefix", p["logstash_prefix"])
 }
+
+type PodsFinderMock struct {
+	pods map[string]*k8s.Pod
+}
+
+func (f *PodsFinderMock) Find(uid string, namespace string, name string) *k8s.Pod {
+	return f.pods[uid]
+}
+
+func TestGetExtendsPodMetadata(t *testing.T) {
+	c := &docker.Container{Config: docker.ConfigSection{Labels: map[string]string{
+		k8s.LabelKubernetesPodUID:        "uid-1",
+		k8s.LabelKubernetesContainerName: "nginx",
+	}}}
+	s := &Service{
+		cfg: &config.Config{
+			K8sLabels:      []string{"app", "app.kubernetes.io/*"},
+			K8sAnnotations: []string{"team"},
+		},
+	}
+	p, err := s.getExtendsForLogs(c)
+	assert.NoError(t, err)
+	assert.NotContains(t, p, reader.KubernetesPodID)
+
+	s.SetPods(&PodsFinderMock{pods: map[string]*k8s.Pod{"uid-1": {
+		UID:         "uid-1",
+		Labels:      map[string]string{"app": "web", "app.kubernetes.io/version": "v1", "pod-template-hash": "5d4f"},
+		Annotations: map[string]string{"team": "core", "checksum/config": "abc"},
+		OwnerKind:   "ReplicaSet",
+		OwnerName:   "web-5d4f",
+		PodIP:       "10.0.0.5",
+		Images:      map[string]string{"nginx": "nginx:1.19"},
+	}}})
+	p, err = s.getExtendsForLogs(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "uid-1", p[reader.KubernetesPodID])
+	assert.Equal(t, "10.0.0.5", p[reader.KubernetesPodIP])
+	assert.Equal(t, "nginx:1.19", p[reader.KubernetesContainerImage])
+	assert.Equal(t, "ReplicaSet", p[reader.KubernetesOwnerKind])
+	assert.Equal(t, "web-5d4f", p[reader.KubernetesOwnerName])
+	assert.Equal(t, "web", p["kubernetes.labels.app"])
+	assert.Equal(t, "v1", p["kubernetes.labels.app_kubernetes_io/version"])
+	assert.NotContains(t, p, "kubernetes.labels.pod-template-hash")
+	assert.Equal(t, "core", p["kubernetes.annotations.team"])
+	assert.NotContains(t, p, "kubernetes.annotations.checksum/config")
+}
+
+func TestPodMetadataRefresh(t *testing.T) {
+	s := &Service{cfg: &config.Config{LogstashPrefix: "k8s-unknown", K8sLabels: []string{"*"}}}
+	pods := &PodsFinderMock{pods: map[string]*k8s.Pod{}}
+	s.SetPods(pods)
+	c := &docker.Container{CRIType: docker.CRI_TYPE_DOCKER, LogPath: "/tmp/app.log"}
+	c.Config = docker.ConfigSection{Labels: map[string]string{
+		k8s.LabelKubernetesPodUID:        "uid-1",
+		k8s.LabelKubernetesContainerName: "app",
+	}}
+	extends, err := s.getExtendsForLogs(c)
+	assert.NoError(t, err)
+	p, err := s.newParser(c, extends)
+	assert.NoError(t, err)
+
+	// Pod is not cached yet when reader is spawned
+	out, _ := p.ParseLine(`{"log":"a\n"}`)
+	assert.NotContains(t, out, reader.KubernetesPodID)
+	assert.Contains(t, out, `"logstash_prefix":"k8s-unknown"`)
+
+	pods.pods["uid-1"] = &k8s.Pod{UID: "uid-1", PodIP: "10.0.0.5", Labels: map[string]string{"version": "v1"},
+		Annotations: map[string]string{k8s.AnnotationRoute: "k8s-payments"}}
+	out, _ = p.ParseLine(`{"log":"b\n"}`)
+	assert.Contains(t, out, `"kubernetes.pod_id":"uid-1"`)
+	assert.Contains(t, out, `"kubernetes.pod_ip":"10.0.0.5"`)
+	assert.Contains(t, out, `"kubernetes.labels.version":"v1"`)
+	assert.Contains(t, out, `"logstash_prefix":"k8s-payments"`)
+
+	// Changed pod replaces metadata, removed label is not kept
+	pods.pods["uid-1"] = &k8s.Pod{UID: "uid-1", PodIP: "10.0.0.6"}
+	out, _ = p.ParseLine(`{"log":"c\n"}`)
+	assert.Contains(t, out, `"kubernetes.pod_ip":"10.0.0.6"`)
+	assert.NotContains(t, out, "kubernetes.labels.version")
+	assert.Contains(t, out, `"logstash_prefix":"k8s-unknown"`)
+}
+
+func TestPodExcludeNotCached(t *testing.T) {
+	dir := t.TempDir()
+	r, err := storage.NewRegistryFile(dir+"/test.db", 1)
+	assert.NoError(t, err)
+	s := &Service{cfg: &config.Config{}, registry: r}
+	pods := &PodsFinderMock{pods: map[string]*k8s.Pod{}}
+	s.SetPods(pods)
+	c := &docker.Container{CRIType: docker.CRI_TYPE_DOCKER, LogPath: dir + "/app.log"}
+	c.Config = docker.ConfigSection{Labels: map[string]string{
+		k8s.LabelKubernetesPodUID:        "uid-1",
+		k8s.LabelKubernetesContainerName: "app",
+	}}
+
+	// Reader is spawned for pod which is not cached yet
+	assert.True(t, s.isNeedToSpawnProcess(c, true))
+	p, err := s.newParser(c, parser.Properties{})
+	assert.NoError(t, err)
+	out, _ := p.ParseLine(`{"log":"a\n"}`)
+	assert.Contains(t, out, `"log":"a\n"`)
+
+	// Exclude annotation of pod cached later stops sending records of running reader
+	pods.pods["uid-1"] = &k8s.Pod{UID: "uid-1", Annotations: map[string]string{k8s.AnnotationExclude + ".app": "true"}}
+	assert.False(t, s.isNeedToSpawnProcess(c, true))
+	out, _ = p.ParseLine(`{"log":"b\n"}`)
+	assert.Equal(t, "", out)
+
+	pods.pods["uid-1"] = &k8s.Pod{UID: "uid-1", Annotations: map[string]string{k8s.AnnotationExclude: "false"}}
+	out, _ = p.ParseLine(`{"log":"c\n"}`)
+	assert.Contains(t, out, `"log":"c\n"`)
+}
+
+func TestPodAnnotations(t *testing.T) {
+	dir := t.TempDir()
+	r, err := storage.NewRegistryFile(dir+"/test.db", 1)
+	assert.NoError(t, err)
+	s := &Service{
+		cfg: &config.Config{
+			LogstashPrefix: "k8s-unknown",
+			ParserOptions:  parser.Options{Processors: []string{parser.ProcessorBody}},
+		},
+		registry: r,
+	}
+	s.SetPods(&PodsFinderMock{pods: map[string]*k8s.Pod{"uid-1": {
+		UID: "uid-1",
+		Annotations: map[string]string{
+			k8s.AnnotationExclude + ".istio-proxy":   "true",
+			k8s.AnnotationParser:                     "logfmt",
+			k8s.AnnotationMultilinePattern + ".java": `^\d`,
+			k8s.AnnotationRoute:                      "k8s-payments",
+			k8s.AnnotationSampleRate + ".debug":      "0",
+		},
+	}}})
+	c := &docker.Container{CRIType: docker.CRI_TYPE_DOCKER, LogPath: dir + "/app.log"}
+	c.Config = docker.ConfigSection{Labels: map[string]string{
+		k8s.LabelKubernetesPodUID:        "uid-1",
+		k8s.LabelKubernetesContainerName: "app",
+	}}
+	assert.True(t, s.isNeedToSpawnProcess(c, true))
+	extends, err := s.getExtendsForLogs(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "k8s-payments", extends["logstash_prefix"])
+	p, err := s.newParser(c, parser.Properties{})
+	assert.NoError(t, err)
+	out, err := p.ParseLine(`{"log":"level=info msg=started\n"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"level":"info","msg":"started"}`, out)
+
+	c.Config.Labels[k8s.LabelKubernetesContainerName] = "istio-proxy"
+	assert.False(t, s.isNeedToSpawnProcess(c, true))
+
+	c.Config.Labels[k8s.LabelKubernetesContainerName] = "java"
+	p, err = s.newParser(c, parser.Properties{})
+	assert.NoError(t, err)
+	out, _ = p.ParseLine(`{"log":"1 Exception\n"}`)
+	assert.Equal(t, "", out)
+	out, _ = p.ParseLine(`{"log":"\tat Main.java\n"}`)
+	assert.Equal(t, "", out)
+	assert.Equal(t, []string{`{"log":"1 Exception\n\tat Main.java\n"}`}, p.Flush(true))
+
+	c.Config.Labels[k8s.LabelKubernetesContainerName] = "debug"
+	p, err = s.newParser(c, parser.Properties{})
+	assert.NoError(t, err)
+	out, _ = p.ParseLine(`{"log":"level=debug\n"}`)
+	assert.Equal(t, "", out)
+
+	// Invalid annotation is ignored
+	s.pods.(*PodsFinderMock).pods["uid-1"].Annotations[k8s.AnnotationParser] = "yaml"
+	c.Config.Labels[k8s.LabelKubernetesContainerName] = "app"
+	p, err = s.newParser(c, parser.Properties{})
+	assert.NoError(t, err)
+	out, _ = p.ParseLine(`{"log":"{\"a\":1}\n"}`)
+	assert.Equal(t, `{"a":1}`, out)
+}
+
+func TestNewParser(t *testing.T) {
+	rule, _ := multiline.ParseRule(`^java.*:start:^\d`)
+	s := &Service{
+		cfg: &config.Config{
+			MultilineRules: []*multiline.Rule{rule},
+			ParserOptions: parser.Options{
+				Processors: []string{parser.ProcessorJSON, parser.ProcessorDrop},
+				Drop:       []string{"time"},
+			},
+		},
+	}
+	c := &docker.Container{CRIType: docker.CRI_TYPE_CRIO}
+	c.Config = docker.ConfigSection{Labels: map[string]string{k8s.LabelKubernetesContainerName: "java-app"}}
+	p, err := s.newParser(c, parser.Properties{"dc": "nsk"})
+	assert.NoError(t, err)
+	out, _ := p.ParseLine("2020-09-10T07:00:03.585507743Z stdout F 1 Exception\n")
+	assert.Equal(t, "", out)
+	out, _ = p.ParseLine("2020-09-10T07:00:03.585507743Z stdout F \tat Main.java\n")
+	assert.Equal(t, "", out)
+	assert.Equal(t, []string{`{"dc":"nsk","log":"1 Exception\n\tat Main.java\n","stream":"stdout"}`}, p.Flush(true))
+
+	c.CRIType = docker.CRI_TYPE_DOCKER
+	c.Config.Labels[k8s.LabelKubernetesContainerName] = "other"
+	p, err = s.newParser(c, parser.Properties{})
+	assert.NoError(t, err)
+	out, err = p.ParseLine(`{"log":"{\"a\":1}","time":"2020-09-10T07:00:03.585507743Z"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":1}`, out)
+
+	logfmt, _ := parser.ParseFormatRule("^go-.*:logfmt")
+	s.cfg.BodyFormatRules = []*parser.FormatRule{logfmt}
+	s.cfg.ParserOptions.Processors = []string{parser.ProcessorBody}
+	c.Config.Labels[k8s.LabelKubernetesContainerName] = "go-app"
+	p, err = s.newParser(c, parser.Properties{})
+	assert.NoError(t, err)
+	out, err = p.ParseLine(`{"log":"level=info msg=started\n"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"level":"info","msg":"started"}`, out)
+
+	grok, _ := parser.ParseGrokRule(`:^go-.*:^%{LOGLEVEL:level} %{GREEDYDATA:message}`, nil)
+	s.cfg.GrokRules = []*parser.GrokRule{grok}
+	p, err = s.newParser(c, parser.Properties{})
+	assert.NoError(t, err)
+	out, err = p.ParseLine(`{"log":"ERROR failed\n"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"level":"ERROR","message":"failed"}`, out)
+
+	c.CRIType = 100
+	_, err = s.newParser(c, parser.Properties{})
+	assert.Error(t, err)
+}
+
+func TestNewParserOversizedPartial(t *testing.T) {
+	s := &Service{cfg: &config.Config{PartialMaxBytes: 1024, PartialTimeoutSec: 5, ReaderMaxLineBytes: 4,
+		ReaderOversizedAction: "truncate"}}
+	c := &docker.Container{CRIType: docker.CRI_TYPE_CONTAINERD}
+	p, err := s.newParser(c, parser.Properties{})
+	assert.NoError(t, err)
+	p.ParseLine("2020-09-10T07:00:03.585507743Z stdout P abc\n")
+	out, _ := p.ParseLine("2020-09-10T07:00:03.585507744Z stdout F def\n")
+	assert.Equal(t, `{"_original_length":7,"_truncated":true,"log":"abcd","stream":"stdout","time":"2020-09-10T07:00:03.585507743Z"}`, out)
+
+	s.cfg.ReaderOversizedAction = "drop"
+	c.CRIType = docker.CRI_TYPE_DOCKER
+	p, err = s.newParser(c, parser.Properties{})
+	assert.NoError(t, err)
+	p.ParseLine(`{"log":"abc"}`)
+	out, _ = p.ParseLine(`{"log":"def\n"}`)
+	assert.Equal(t, "", out)
+}
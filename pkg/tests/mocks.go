@@ -1,6 +1,9 @@
 package tests
 
-import "fmt"
+import (
+	"fmt"
+	"sync"
+)
 
 // RedisClientMock mock for redis client
 type RedisClientMock struct {
@@ -45,3 +48,65 @@ func (r *RedisClientMock) GetBuffer() []string {
 func (r *RedisClientMock) GetClosed() bool {
 	return r.closed
 }
+
+// TransportMock mock for transport which stores all delivered batches
+type TransportMock struct {
+	mu      sync.Mutex
+	batches [][]string
+	broken  bool
+	closed  bool
+}
+
+// SetBroken switch mock to state when each delivery fails
+func (t *TransportMock) SetBroken(broken bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.broken = broken
+}
+
+// DeliverMessages store batch in mock
+func (t *TransportMock) DeliverMessages(data []string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.broken {
+		return fmt.Errorf("Exception (504) Reason: \"channel/connection is not open\"")
+	}
+	t.batches = append(t.batches, data)
+	return nil
+}
+
+// HealthCheck fails when mock is broken
+func (t *TransportMock) HealthCheck() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.broken {
+		return fmt.Errorf("dial tcp: connection refused")
+	}
+	return nil
+}
+
+// Close do nothing in mock
+func (t *TransportMock) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	return nil
+}
+
+// GetMessages return all delivered messages in delivery order
+func (t *TransportMock) GetMessages() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var output []string
+	for _, batch := range t.batches {
+		output = append(output, batch...)
+	}
+	return output
+}
+
+// GetClosed return state of mock connection
+func (t *TransportMock) GetClosed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
@@ -72,6 +72,17 @@ func (b *Broker) closedWatcher() {
 	}
 }
 
+// HealthCheck opens temporary channel to broker and checks that exchange exists
+func (b *Broker) HealthCheck() error {
+	ch, err := b.connection.Channel()
+	if err != nil {
+		return errors.Wrap(err, "Unable to open channel to amqp broker")
+	}
+	defer ch.Close()
+	err = ch.ExchangeDeclarePassive(b.exchange, "direct", true, false, false, false, nil)
+	return errors.Wrapf(err, "Unable to find exchange '%s'", b.exchange)
+}
+
 // Close close all channels and connection
 func (b *Broker) Close() error {
 	return b.connection.Close()
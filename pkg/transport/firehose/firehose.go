@@ -2,6 +2,7 @@ package firehose
 
 import (
 	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	fh "github.com/aws/aws-sdk-go/service/firehose"
 )
@@ -26,6 +27,24 @@ func (f *FireHose) DeliverMessages(strings []string) error {
 	return nil
 }
 
+// HealthCheck checks that delivery stream exists and active
+func (f *FireHose) HealthCheck() error {
+	out, err := f.client.DescribeDeliveryStream(&fh.DescribeDeliveryStreamInput{
+		DeliveryStreamName: &f.deliveryStream,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to describe delivery stream %s, %w", f.deliveryStream, err)
+	}
+	if out.DeliveryStreamDescription == nil {
+		return fmt.Errorf("delivery stream %s has no description", f.deliveryStream)
+	}
+	status := aws.StringValue(out.DeliveryStreamDescription.DeliveryStreamStatus)
+	if status != fh.DeliveryStreamStatusActive {
+		return fmt.Errorf("delivery stream %s is in status %s", f.deliveryStream, status)
+	}
+	return nil
+}
+
 func (f *FireHose) Close() error {
 	return nil
 }
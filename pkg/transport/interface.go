@@ -5,3 +5,17 @@ type ITransportClient interface {
 	DeliverMessages([]string) error
 	Close() error
 }
+
+// IHealthChecker is implemented by transports which are able to check
+// connectivity to log storage without delivering messages
+type IHealthChecker interface {
+	HealthCheck() error
+}
+
+// HealthCheck checks transport health if transport supports it, otherwise assumes it is healthy
+func HealthCheck(t ITransportClient) error {
+	if c, ok := t.(IHealthChecker); ok {
+		return c.HealthCheck()
+	}
+	return nil
+}
@@ -45,6 +45,11 @@ func (r *RedisClient) ReceiveMessage() ([]byte, error) {
 	return msg.Bytes()
 }
 
+// HealthCheck pings redis server
+func (r *RedisClient) HealthCheck() error {
+	return r.client.Ping(context.Background()).Err()
+}
+
 // Close close connection
 func (r *RedisClient) Close() error {
 	return r.client.Close()